@@ -0,0 +1,253 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"time"
+
+	"github.com/binpossible49/go-libs/opentracing/jaeger"
+	"github.com/go-redis/redis/v7"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+// Item is an entry written by MSet
+type Item struct {
+	Key        string
+	Value      interface{}
+	Expiration time.Duration
+}
+
+// MGet gets keys into values like BatchCacheHelper.MGet, with one Get per key when
+// helper does not implement BatchCacheHelper
+func MGet(ctx context.Context, helper CacheHelper, keys []string, values interface{}) error {
+	if batch, ok := helper.(BatchCacheHelper); ok {
+		return batch.MGet(ctx, keys, values)
+	}
+	return fillMGet(keys, values, func(i int, value interface{}) (bool, error) {
+		err := helper.Get(ctx, keys[i], value)
+		if errors.Is(err, ErrNotFound) {
+			return false, nil
+		}
+		return err == nil, err
+	})
+}
+
+// MSet sets items like BatchCacheHelper.MSet, with one Set per item when helper does
+// not implement BatchCacheHelper
+func MSet(ctx context.Context, helper CacheHelper, items ...Item) error {
+	if batch, ok := helper.(BatchCacheHelper); ok {
+		return batch.MSet(ctx, items...)
+	}
+	for _, item := range items {
+		if err := helper.Set(ctx, item.Key, item.Value, item.Expiration); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fillMGetResult decodes raws, nil for missing keys, into values which is a pointer to
+// a slice (one element per key, missing ones are left zero) or to a map keyed by key
+// (missing ones are omitted)
+func fillMGetResult(keys []string, raws [][]byte, values interface{}, decode func(data []byte, value interface{}) error) error {
+	return fillMGet(keys, values, func(i int, value interface{}) (bool, error) {
+		if raws[i] == nil {
+			return false, nil
+		}
+		return true, decode(raws[i], value)
+	})
+}
+
+// fillMGet fills values like fillMGetResult, get reads the value of keys[i] into value
+// and reports whether the key exists
+func fillMGet(keys []string, values interface{}, get func(i int, value interface{}) (bool, error)) error {
+	target := reflect.ValueOf(values)
+	if target.Kind() != reflect.Ptr || target.IsNil() {
+		return errors.New("cache: MGet values must be a non-nil pointer to a slice or a map")
+	}
+	target = target.Elem()
+
+	switch target.Kind() {
+	case reflect.Slice:
+		result := reflect.MakeSlice(target.Type(), len(keys), len(keys))
+		for i := range keys {
+			if _, err := get(i, result.Index(i).Addr().Interface()); err != nil {
+				return err
+			}
+		}
+		target.Set(result)
+	case reflect.Map:
+		if target.Type().Key().Kind() != reflect.String {
+			return errors.New("cache: MGet map values must be keyed by string")
+		}
+		if target.IsNil() {
+			target.Set(reflect.MakeMapWithSize(target.Type(), len(keys)))
+		}
+		for i, key := range keys {
+			element := reflect.New(target.Type().Elem())
+			found, err := get(i, element.Interface())
+			if err != nil {
+				return err
+			}
+			if found {
+				target.SetMapIndex(reflect.ValueOf(key).Convert(target.Type().Key()), element.Elem())
+			}
+		}
+	default:
+		return errors.New("cache: MGet values must be a non-nil pointer to a slice or a map")
+	}
+	return nil
+}
+
+// mgetElementType returns the element type of the slice or map values points to
+func mgetElementType(values interface{}) (reflect.Type, error) {
+	target := reflect.TypeOf(values)
+	if target == nil || target.Kind() != reflect.Ptr {
+		return nil, errors.New("cache: MGet values must be a non-nil pointer to a slice or a map")
+	}
+	switch target.Elem().Kind() {
+	case reflect.Slice, reflect.Map:
+		return target.Elem().Elem(), nil
+	}
+	return nil, errors.New("cache: MGet values must be a non-nil pointer to a slice or a map")
+}
+
+// mgetRaws converts an MGET reply into raw values, nil for missing keys
+func mgetRaws(replies []interface{}) [][]byte {
+	raws := make([][]byte, len(replies))
+	for i, reply := range replies {
+		if data, ok := reply.(string); ok {
+			raws[i] = []byte(data)
+		}
+	}
+	return raws
+}
+
+// Pipeline queues commands and sends them in one round trip per node on Exec,
+// with a cluster the commands are split by node, or by slot when transactional
+type Pipeline interface {
+	Get(key string, value interface{}) *PipelineCmd
+	Set(key string, value interface{}, expiration time.Duration) *PipelineCmd
+	Del(keys ...string) *PipelineCmd
+	Expire(key string, expiration time.Duration) *PipelineCmd
+	Exec(ctx context.Context) error
+}
+
+// PipelineCmd is a queued command, its result is known after Exec
+type PipelineCmd struct {
+	cmd    redis.Cmder
+	decode func() error
+	err    error
+}
+
+// Err returns the error of the command, one matching ErrNotFound for a missing key
+func (c *PipelineCmd) Err() error {
+	return c.err
+}
+
+type pipeline struct {
+	name      string
+	pipeliner redis.Pipeliner
+	codec     *codec
+	cluster   bool
+	cmds      []*PipelineCmd
+}
+
+// NewPipeline creates a pipeline on helper, transactional wraps the commands in MULTI/EXEC.
+// Only helpers created by NewCacheHelper are supported, decorators would be bypassed.
+func NewPipeline(helper CacheHelper, transactional bool) (Pipeline, error) {
+	h, ok := helper.(redisClientHelper)
+	if !ok {
+		return nil, ErrUnsupportedHelper
+	}
+	_, cluster := h.redisClient().(*redis.ClusterClient)
+	p := &pipeline{codec: h.valueCodec(), cluster: cluster}
+	if transactional {
+		p.name, p.pipeliner = "TxPipeline", h.redisClient().TxPipeline()
+	} else {
+		p.name, p.pipeliner = "Pipeline", h.redisClient().Pipeline()
+	}
+	return p, nil
+}
+
+func (p *pipeline) queue(cmd redis.Cmder, decode func() error) *PipelineCmd {
+	pipelineCmd := &PipelineCmd{cmd: cmd, decode: decode}
+	p.cmds = append(p.cmds, pipelineCmd)
+	return pipelineCmd
+}
+
+func (p *pipeline) Get(key string, value interface{}) *PipelineCmd {
+	cmd := p.pipeliner.Get(key)
+	return p.queue(cmd, func() error {
+		data, err := cmd.Bytes()
+		if err != nil {
+			return err
+		}
+		return p.codec.decode(data, value)
+	})
+}
+
+func (p *pipeline) Set(key string, value interface{}, expiration time.Duration) *PipelineCmd {
+	data, err := p.codec.encode(value)
+	if err != nil {
+		pipelineCmd := &PipelineCmd{err: err}
+		p.cmds = append(p.cmds, pipelineCmd)
+		return pipelineCmd
+	}
+	return p.queue(p.pipeliner.Set(key, data, expiration), nil)
+}
+
+func (p *pipeline) Del(keys ...string) *PipelineCmd {
+	if !p.cluster || len(keys) < 2 {
+		return p.queue(p.pipeliner.Del(keys...), nil)
+	}
+	// DEL across slots is rejected with CROSSSLOT, so queue one DEL per slot
+	var cmds []*redis.IntCmd
+	for _, slotKeys := range groupKeysBySlot(keys) {
+		cmds = append(cmds, p.pipeliner.Del(slotKeys...))
+	}
+	return p.queue(nil, func() error {
+		for _, cmd := range cmds {
+			if err := cmd.Err(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (p *pipeline) Expire(key string, expiration time.Duration) *PipelineCmd {
+	return p.queue(p.pipeliner.Expire(key, expiration), nil)
+}
+
+// Exec sends the queued commands and returns the first error other than a miss
+func (p *pipeline) Exec(ctx context.Context) (err error) {
+	span := jaeger.Start(ctx, ">helper.pipeline/"+p.name, ext.SpanKindRPCClient, opentracing.Tag{Key: "pipeline.commands", Value: len(p.cmds)})
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+	defer p.pipeliner.Close()
+
+	if len(p.cmds) == 0 {
+		return nil
+	}
+	_, execErr := p.pipeliner.ExecContext(ctx)
+	for _, cmd := range p.cmds {
+		if cmd.err == nil && cmd.decode == nil {
+			cmd.err = cmd.cmd.Err()
+		}
+		if cmd.err == nil && cmd.decode != nil {
+			cmd.err = cmd.decode()
+		}
+		cmd.err = wrapNotFound(cmd.err)
+		if cmd.err != nil && !errors.Is(cmd.err, ErrNotFound) && err == nil {
+			err = cmd.err
+		}
+	}
+	if err == nil && execErr != nil && execErr != redis.Nil {
+		err = execErr
+	}
+	return err
+}
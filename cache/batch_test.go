@@ -0,0 +1,153 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+func TestFillMGetResult(t *testing.T) {
+	keys := []string{"a", "b", "c"}
+	raws := [][]byte{[]byte(`1`), nil, []byte(`3`)}
+
+	var slice []int
+	if err := fillMGetResult(keys, raws, &slice, json.Unmarshal); err != nil {
+		t.Fatal(err)
+	}
+	if len(slice) != 3 || slice[0] != 1 || slice[1] != 0 || slice[2] != 3 {
+		t.Errorf("slice = %v, want [1 0 3]", slice)
+	}
+
+	var m map[string]*int
+	if err := fillMGetResult(keys, raws, &m, json.Unmarshal); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m["b"]; len(m) != 2 || ok || *m["c"] != 3 {
+		t.Errorf("map = %v, want a and c only", m)
+	}
+
+	if err := fillMGetResult(keys, raws, slice, json.Unmarshal); err == nil {
+		t.Errorf("expected an error for a non-pointer")
+	}
+}
+
+func TestRedisMGetMSet(t *testing.T) {
+	ctx := context.Background()
+	server, helper := newTestRedisHelper(t)
+
+	err := MSet(ctx, helper, Item{Key: "a", Value: 1, Expiration: time.Minute}, Item{Key: "c", Value: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl := server.TTL("a"); ttl != time.Minute {
+		t.Errorf("TTL of a = %v, want the expiration of its item", ttl)
+	}
+	if ttl := server.TTL("c"); ttl != 0 {
+		t.Errorf("TTL of c = %v, want none", ttl)
+	}
+
+	var values []int
+	if err := MGet(ctx, helper, []string{"a", "b", "c"}, &values); err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 3 || values[0] != 1 || values[1] != 0 || values[2] != 3 {
+		t.Errorf("MGet() = %v, want [1 0 3]", values)
+	}
+}
+
+// getSetHelper hides the batch methods of the helper it embeds
+type getSetHelper struct {
+	CacheHelper
+}
+
+func TestMGetMSetFallback(t *testing.T) {
+	ctx := context.Background()
+	helper := getSetHelper{NewMemoryCacheHelper(nil)}
+	if _, ok := interface{}(helper).(BatchCacheHelper); ok {
+		t.Fatal("getSetHelper should not implement BatchCacheHelper")
+	}
+
+	if err := MSet(ctx, helper, Item{Key: "a", Value: "x"}, Item{Key: "b", Value: "y"}); err != nil {
+		t.Fatal(err)
+	}
+	values := map[string]string{}
+	if err := MGet(ctx, helper, []string{"a", "missing", "b"}, &values); err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 2 || values["a"] != "x" || values["b"] != "y" {
+		t.Errorf("MGet() = %v, want a and b", values)
+	}
+	var slice []string
+	if err := MGet(ctx, helper, []string{"missing", "b"}, &slice); err != nil || len(slice) != 2 || slice[1] != "y" {
+		t.Errorf("MGet() = %q, %v", slice, err)
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	ctx := context.Background()
+	server, helper := newTestRedisHelper(t)
+	server.Set("old", "1")
+
+	for _, transactional := range []bool{false, true} {
+		p, err := NewPipeline(helper, transactional)
+		if err != nil {
+			t.Fatal(err)
+		}
+		set := p.Set("a", "value", time.Minute)
+		var value, missing string
+		get := p.Get("a", &value)
+		miss := p.Get("missing", &missing)
+		expire := p.Expire("a", time.Hour)
+		del := p.Del("old", "gone")
+		if err := p.Exec(ctx); err != nil {
+			t.Fatalf("Exec() = %v", err)
+		}
+
+		if set.Err() != nil || get.Err() != nil || expire.Err() != nil || del.Err() != nil {
+			t.Errorf("command errors = %v, %v, %v, %v", set.Err(), get.Err(), expire.Err(), del.Err())
+		}
+		if value != "value" {
+			t.Errorf("Get() = %q, want the value set earlier in the pipeline", value)
+		}
+		if !errors.Is(miss.Err(), ErrNotFound) {
+			t.Errorf("Get() of a missing key = %v, want ErrNotFound", miss.Err())
+		}
+		if ttl := server.TTL("a"); ttl != time.Hour {
+			t.Errorf("TTL = %v, want 1h", ttl)
+		}
+		if server.Exists("old") {
+			t.Error("Del() should delete old")
+		}
+	}
+
+	if _, err := NewPipeline(NewMemoryCacheHelper(nil), false); err != ErrUnsupportedHelper {
+		t.Errorf("NewPipeline() of a memory helper = %v, want ErrUnsupportedHelper", err)
+	}
+}
+
+func TestPipelineClusterDelBySlot(t *testing.T) {
+	server, _ := newTestRedisHelper(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+	server.Set("{user}:a", "1")
+	server.Set("{user}:b", "1")
+	server.Set("order", "1")
+
+	p := &pipeline{name: "Pipeline", pipeliner: client.Pipeline(), codec: defaultCodec, cluster: true}
+	del := p.Del("{user}:a", "order", "{user}:b")
+	cmds, err := p.pipeliner.Exec()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cmds) != 2 {
+		t.Errorf("queued %d DEL, want one per slot", len(cmds))
+	}
+	del.err = del.decode()
+	if del.Err() != nil || server.Exists("order") || server.Exists("{user}:a") {
+		t.Errorf("Del() = %v, want every key deleted", del.Err())
+	}
+}
@@ -0,0 +1,344 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	defaultBreakerFailureThreshold = 5
+	defaultBreakerOpenTimeout      = 30 * time.Second
+	defaultBreakerName             = "default"
+)
+
+// BreakerState is the state of a circuit breaker
+type BreakerState int
+
+const (
+	// BreakerClosed lets every call through
+	BreakerClosed BreakerState = iota
+	// BreakerOpen short-circuits every call
+	BreakerOpen
+	// BreakerHalfOpen lets a single call through to probe redis
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// BreakerOptions represents options of the circuit breaker CacheHelper
+type BreakerOptions struct {
+	// Name tells the breakers of a process apart in logs and metrics, "default" by default
+	Name string
+	// FailureThreshold is the number of consecutive failures opening the circuit, 5 by default
+	FailureThreshold int
+	// OpenTimeout is how long the circuit stays open before a call probes redis, 30s by default
+	OpenTimeout time.Duration
+	// Fallback serves calls while the circuit is open, reads are misses and writes
+	// are dropped when nil
+	Fallback CacheHelper
+	// OnStateChange is called on every transition, the breaker is not locked so it may be used
+	OnStateChange func(from, to BreakerState)
+	// Metrics receives the state when not nil
+	Metrics Metrics
+}
+
+// CircuitBreakerCacheHelper is a CacheHelper guarded by a circuit breaker
+type CircuitBreakerCacheHelper interface {
+	CacheHelper
+	State() BreakerState
+}
+
+type circuitBreakerCacheHelper struct {
+	inner CacheHelper
+	opts  BreakerOptions
+	now   func() time.Time
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+	// changes are the transitions made under mu, reported once it is released
+	changes []stateChange
+}
+
+type stateChange struct {
+	from, to BreakerState
+}
+
+// NewCircuitBreakerCacheHelper creates an instance opening the circuit after consecutive
+// connection failures or timeouts of inner, misses, errors replied by redis and calls whose
+// context was canceled or expired are not failures
+func NewCircuitBreakerCacheHelper(inner CacheHelper, opts BreakerOptions) CircuitBreakerCacheHelper {
+	if opts.FailureThreshold <= 0 {
+		opts.FailureThreshold = defaultBreakerFailureThreshold
+	}
+	if opts.OpenTimeout <= 0 {
+		opts.OpenTimeout = defaultBreakerOpenTimeout
+	}
+	if opts.Name == "" {
+		opts.Name = defaultBreakerName
+	}
+	if opts.Metrics != nil {
+		opts.Metrics.ObserveBreakerState(opts.Name, BreakerClosed)
+	}
+	return &circuitBreakerCacheHelper{
+		inner: inner,
+		opts:  opts,
+		now:   time.Now,
+	}
+}
+
+func (h *circuitBreakerCacheHelper) unwrap() CacheHelper {
+	return h.inner
+}
+
+func (h *circuitBreakerCacheHelper) State() BreakerState {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.state
+}
+
+// allow tells whether a call may go to inner, a half-open circuit lets one probe through
+func (h *circuitBreakerCacheHelper) allow() bool {
+	h.mu.Lock()
+	defer h.unlock()
+
+	switch h.state {
+	case BreakerOpen:
+		if h.now().Sub(h.openedAt) < h.opts.OpenTimeout {
+			return false
+		}
+		h.setState(BreakerHalfOpen)
+		fallthrough
+	case BreakerHalfOpen:
+		if h.probing {
+			return false
+		}
+		h.probing = true
+	}
+	return true
+}
+
+// record updates the circuit with the result of a call allowed through. A call that
+// failed because its own ctx was canceled or timed out says nothing about redis.
+func (h *circuitBreakerCacheHelper) record(ctx context.Context, err error) {
+	failed := isConnectionFailure(err)
+	canceled := err != nil && contextErr(ctx) != nil
+
+	h.mu.Lock()
+	defer h.unlock()
+
+	if canceled {
+		if h.state == BreakerHalfOpen {
+			h.probing = false
+		}
+		return
+	}
+	if h.state == BreakerHalfOpen {
+		h.probing = false
+		if failed {
+			h.open()
+		} else {
+			h.failures = 0
+			h.setState(BreakerClosed)
+		}
+		return
+	}
+	if !failed {
+		h.failures = 0
+		return
+	}
+	h.failures++
+	if h.state == BreakerClosed && h.failures >= h.opts.FailureThreshold {
+		h.open()
+	}
+}
+
+func (h *circuitBreakerCacheHelper) open() {
+	h.openedAt = h.now()
+	h.setState(BreakerOpen)
+}
+
+// setState changes the state, callers hold mu and release it with unlock
+func (h *circuitBreakerCacheHelper) setState(state BreakerState) {
+	if h.state == state {
+		return
+	}
+	h.changes = append(h.changes, stateChange{from: h.state, to: state})
+	h.state = state
+}
+
+// unlock releases mu and then reports the transitions, so that callbacks may use the breaker
+func (h *circuitBreakerCacheHelper) unlock() {
+	changes, failures := h.changes, h.failures
+	h.changes = nil
+	h.mu.Unlock()
+
+	for _, change := range changes {
+		zap.S().Warnw("Cache circuit breaker state changed", "breaker", h.opts.Name, "from", change.from.String(), "to", change.to.String(), "failures", failures)
+		if h.opts.Metrics != nil {
+			h.opts.Metrics.ObserveBreakerState(h.opts.Name, change.to)
+		}
+		if h.opts.OnStateChange != nil {
+			h.opts.OnStateChange(change.from, change.to)
+		}
+	}
+}
+
+// isConnectionFailure tells whether err means redis could not be reached in time
+func isConnectionFailure(err error) bool {
+	if err == nil || errors.Is(err, ErrNotFound) {
+		return false
+	}
+	var netErr net.Error
+	switch {
+	case errors.As(err, &netErr),
+		errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, io.EOF),
+		errors.Is(err, io.ErrUnexpectedEOF):
+		return true
+	}
+	// returned by go-redis as plain errors
+	switch err.Error() {
+	case "redis: connection pool timeout", "redis: client is closed":
+		return true
+	}
+	return false
+}
+
+func (h *circuitBreakerCacheHelper) Exists(ctx context.Context, key string) error {
+	if !h.allow() {
+		if h.opts.Fallback != nil {
+			return h.opts.Fallback.Exists(ctx, key)
+		}
+		return &notFoundError{}
+	}
+	err := h.inner.Exists(ctx, key)
+	h.record(ctx, err)
+	return err
+}
+
+func (h *circuitBreakerCacheHelper) Get(ctx context.Context, key string, value interface{}) error {
+	if !h.allow() {
+		if h.opts.Fallback != nil {
+			return h.opts.Fallback.Get(ctx, key, value)
+		}
+		return &notFoundError{}
+	}
+	err := h.inner.Get(ctx, key, value)
+	h.record(ctx, err)
+	return err
+}
+
+func (h *circuitBreakerCacheHelper) GetInterface(ctx context.Context, key string, value interface{}) (interface{}, error) {
+	if !h.allow() {
+		if h.opts.Fallback != nil {
+			return h.opts.Fallback.GetInterface(ctx, key, value)
+		}
+		return nil, &notFoundError{}
+	}
+	result, err := h.inner.GetInterface(ctx, key, value)
+	h.record(ctx, err)
+	return result, err
+}
+
+func (h *circuitBreakerCacheHelper) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	if !h.allow() {
+		if h.opts.Fallback != nil {
+			return h.opts.Fallback.Set(ctx, key, value, expiration)
+		}
+		return nil
+	}
+	err := h.inner.Set(ctx, key, value, expiration)
+	h.record(ctx, err)
+	return err
+}
+
+func (h *circuitBreakerCacheHelper) Del(ctx context.Context, key string) error {
+	if !h.allow() {
+		if h.opts.Fallback != nil {
+			return h.opts.Fallback.Del(ctx, key)
+		}
+		return nil
+	}
+	err := h.inner.Del(ctx, key)
+	h.record(ctx, err)
+	return err
+}
+
+func (h *circuitBreakerCacheHelper) Expire(ctx context.Context, key string, expiration time.Duration) error {
+	if !h.allow() {
+		if h.opts.Fallback != nil {
+			return h.opts.Fallback.Expire(ctx, key, expiration)
+		}
+		return nil
+	}
+	err := h.inner.Expire(ctx, key, expiration)
+	h.record(ctx, err)
+	return err
+}
+
+func (h *circuitBreakerCacheHelper) DelMulti(ctx context.Context, keys ...string) error {
+	if !h.allow() {
+		if h.opts.Fallback != nil {
+			return h.opts.Fallback.DelMulti(ctx, keys...)
+		}
+		return nil
+	}
+	err := h.inner.DelMulti(ctx, keys...)
+	h.record(ctx, err)
+	return err
+}
+
+func (h *circuitBreakerCacheHelper) GetKeysByPattern(ctx context.Context, pattern string, cursor uint64, limit int64) ([]string, uint64, error) {
+	if !h.allow() {
+		if h.opts.Fallback != nil {
+			return h.opts.Fallback.GetKeysByPattern(ctx, pattern, cursor, limit)
+		}
+		return []string{}, 0, nil
+	}
+	keys, next, err := h.inner.GetKeysByPattern(ctx, pattern, cursor, limit)
+	h.record(ctx, err)
+	return keys, next, err
+}
+
+func (h *circuitBreakerCacheHelper) MGet(ctx context.Context, keys []string, values interface{}) error {
+	if !h.allow() {
+		if h.opts.Fallback != nil {
+			return MGet(ctx, h.opts.Fallback, keys, values)
+		}
+		return fillMGetResult(keys, make([][]byte, len(keys)), values, defaultCodec.decode)
+	}
+	err := MGet(ctx, h.inner, keys, values)
+	h.record(ctx, err)
+	return err
+}
+
+func (h *circuitBreakerCacheHelper) MSet(ctx context.Context, items ...Item) error {
+	if !h.allow() {
+		if h.opts.Fallback != nil {
+			return MSet(ctx, h.opts.Fallback, items...)
+		}
+		return nil
+	}
+	err := MSet(ctx, h.inner, items...)
+	h.record(ctx, err)
+	return err
+}
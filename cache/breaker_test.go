@@ -0,0 +1,168 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+// unreachableCacheHelper fails every Get like a redis that cannot be reached
+type unreachableCacheHelper struct {
+	CacheHelper
+	err error
+}
+
+func (h *unreachableCacheHelper) Get(ctx context.Context, key string, value interface{}) error {
+	if h.err != nil {
+		return h.err
+	}
+	return h.CacheHelper.Get(ctx, key, value)
+}
+
+func TestCircuitBreakerCacheHelper(t *testing.T) {
+	ctx := context.Background()
+	inner := &unreachableCacheHelper{
+		CacheHelper: NewMemoryCacheHelper(nil),
+		err:         &net.OpError{Op: "dial", Err: errors.New("connection refused")},
+	}
+	inner.Set(ctx, "a", "1", 0)
+
+	var transitions []BreakerState
+	h := NewCircuitBreakerCacheHelper(inner, BreakerOptions{
+		FailureThreshold: 2,
+		OpenTimeout:      time.Second,
+		OnStateChange: func(from, to BreakerState) {
+			transitions = append(transitions, to)
+		},
+	}).(*circuitBreakerCacheHelper)
+	now := time.Unix(0, 0)
+	h.now = func() time.Time { return now }
+
+	var value string
+	for i := 0; i < 2; i++ {
+		if err := h.Get(ctx, "a", &value); !isConnectionFailure(err) {
+			t.Fatalf("Get() = %v, want the connection failure", err)
+		}
+	}
+	if h.State() != BreakerOpen {
+		t.Fatalf("State() = %v, want open", h.State())
+	}
+	if err := h.Get(ctx, "a", &value); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() while open = %v, want a miss", err)
+	}
+	if err := h.Set(ctx, "b", "2", 0); err != nil {
+		t.Errorf("Set() while open = %v, want nil", err)
+	}
+
+	now = now.Add(time.Second)
+	inner.err = nil
+	if err := h.Get(ctx, "a", &value); err != nil || value != "1" {
+		t.Errorf("probe Get() = %q, %v, want 1, nil", value, err)
+	}
+	if h.State() != BreakerClosed {
+		t.Errorf("State() = %v, want closed after a successful probe", h.State())
+	}
+
+	want := []BreakerState{BreakerOpen, BreakerHalfOpen, BreakerClosed}
+	if len(transitions) != len(want) {
+		t.Fatalf("transitions = %v, want %v", transitions, want)
+	}
+	for i := range want {
+		if transitions[i] != want[i] {
+			t.Errorf("transitions = %v, want %v", transitions, want)
+		}
+	}
+}
+
+func TestCircuitBreakerCallbackReadsState(t *testing.T) {
+	ctx := context.Background()
+	inner := &unreachableCacheHelper{
+		CacheHelper: NewMemoryCacheHelper(nil),
+		err:         &net.OpError{Op: "dial", Err: errors.New("connection refused")},
+	}
+	var h CircuitBreakerCacheHelper
+	states := make(chan BreakerState, 1)
+	h = NewCircuitBreakerCacheHelper(inner, BreakerOptions{
+		FailureThreshold: 1,
+		OnStateChange: func(from, to BreakerState) {
+			// the callback runs once the breaker is unlocked
+			states <- h.State()
+		},
+	})
+
+	done := make(chan struct{})
+	go func() {
+		var value string
+		h.Get(ctx, "a", &value)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Get() deadlocked in OnStateChange")
+	}
+	if state := <-states; state != BreakerOpen {
+		t.Errorf("State() in OnStateChange = %v, want open", state)
+	}
+}
+
+func TestCircuitBreakerIgnoresCallerCancellation(t *testing.T) {
+	inner := &unreachableCacheHelper{CacheHelper: NewMemoryCacheHelper(nil)}
+	h := NewCircuitBreakerCacheHelper(inner, BreakerOptions{FailureThreshold: 1, OpenTimeout: time.Second}).(*circuitBreakerCacheHelper)
+	now := time.Unix(0, 0)
+	h.now = func() time.Time { return now }
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// the caller gave up, redis is not to blame
+	var value string
+	inner.err = context.Canceled
+	h.Get(canceled, "a", &value)
+	expired, cancelExpired := context.WithDeadline(context.Background(), now)
+	defer cancelExpired()
+	inner.err = context.DeadlineExceeded
+	h.Get(expired, "a", &value)
+	if h.State() != BreakerClosed {
+		t.Fatalf("State() after canceled calls = %v, want closed", h.State())
+	}
+
+	// a timeout of the helper itself is a failure
+	h.Get(context.Background(), "a", &value)
+	if h.State() != BreakerOpen {
+		t.Fatalf("State() after a timeout = %v, want open", h.State())
+	}
+
+	// a canceled probe does not close the circuit and lets the next call probe
+	now = now.Add(time.Second)
+	inner.err = context.Canceled
+	h.Get(canceled, "a", &value)
+	if h.State() != BreakerHalfOpen {
+		t.Errorf("State() after a canceled probe = %v, want half-open", h.State())
+	}
+	if !h.allow() {
+		t.Error("allow() after a canceled probe = false, want another probe")
+	}
+}
+
+func TestIsConnectionFailure(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{redis.Nil, false},
+		{errors.New("WRONGTYPE Operation against a key holding the wrong kind of value"), false},
+		{context.DeadlineExceeded, true},
+		{&net.OpError{Op: "read", Err: errors.New("i/o timeout")}, true},
+		{errors.New("redis: connection pool timeout"), true},
+	}
+	for _, test := range tests {
+		if got := isConnectionFailure(test.err); got != test.want {
+			t.Errorf("isConnectionFailure(%v) = %v, want %v", test.err, got, test.want)
+		}
+	}
+}
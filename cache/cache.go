@@ -2,15 +2,53 @@ package cache
 
 import (
 	"context"
+	"errors"
+	"reflect"
 	"time"
 
+	"github.com/go-redis/redis/v7"
 	"go.uber.org/zap"
 )
 
-// CacheHelper is helper of Cache
+var (
+	// ErrUnsupportedHelper is returned when a feature needs a redis client but the helper is not backed by one
+	ErrUnsupportedHelper = errors.New("cache: helper is not backed by a redis client")
+	// ErrNotFound matches the errors returned when a key does not exist. Misses are
+	// compared with errors.Is, which matches redis.Nil as well.
+	ErrNotFound = errors.New("cache: not found")
+)
+
+// notFoundError is a miss, it matches ErrNotFound and redis.Nil and keeps the error
+// replied by the driver, if any, as its cause
+type notFoundError struct {
+	cause error
+}
+
+func (e *notFoundError) Error() string {
+	return ErrNotFound.Error()
+}
+
+func (e *notFoundError) Unwrap() error {
+	return e.cause
+}
+
+func (e *notFoundError) Is(target error) bool {
+	return target == ErrNotFound || target == redis.Nil
+}
+
+// wrapNotFound turns the miss of a redis command into a notFoundError, other errors are kept
+func wrapNotFound(err error) error {
+	if err == redis.Nil {
+		return &notFoundError{cause: err}
+	}
+	return err
+}
+
+// CacheHelper is helper of Cache, misses return errors matching ErrNotFound
 type CacheHelper interface {
 	Exists(ctx context.Context, key string) error
 	Get(ctx context.Context, key string, value interface{}) error
+	// Deprecated: GetInterface guesses the result type by reflection, use GetInto instead
 	GetInterface(ctx context.Context, key string, value interface{}) (interface{}, error)
 	Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error
 	Del(ctx context.Context, key string) error
@@ -19,22 +57,160 @@ type CacheHelper interface {
 	GetKeysByPattern(ctx context.Context, pattern string, cursor uint64, limit int64) ([]string, uint64, error)
 }
 
+// BatchCacheHelper is a CacheHelper reading and writing several keys in one round trip,
+// every helper of this package implements it. The MGet and MSet functions accept any
+// CacheHelper and fall back to one call per key.
+type BatchCacheHelper interface {
+	CacheHelper
+	// MGet gets keys into values, a pointer to a slice filled in the order of keys
+	// or to a map[string]T, missing keys are left zero in a slice and omitted in a map
+	MGet(ctx context.Context, keys []string, values interface{}) error
+	// MSet sets items, each one with its own expiration
+	MSet(ctx context.Context, items ...Item) error
+}
+
 // NewCacheHelper creates an instance
 func NewCacheHelper(addrs []string) CacheHelper {
-	if len(addrs) > 1 {
-		clusterClient, err := initRedisCluster(addrs)
+	helper, err := NewCacheHelperWithOptions(Options{Addrs: addrs})
+	if err != nil {
+		zap.S().Panic("Failed to init redis", zap.Error(err))
+	}
+	return helper
+}
+
+// NewSentinelCacheHelper creates an instance using sentinel for master discovery and failover
+func NewSentinelCacheHelper(masterName string, sentinelAddrs []string) CacheHelper {
+	helper, err := NewCacheHelperWithOptions(Options{
+		Addrs:      sentinelAddrs,
+		MasterName: masterName,
+	})
+	if err != nil {
+		zap.S().Panic("Failed to init redis sentinel", zap.Error(err))
+	}
+	return helper
+}
+
+// NewCacheHelperWithOptions creates an instance from options,
+// a master name creates a sentinel helper and more than one address creates a cluster helper
+func NewCacheHelperWithOptions(opts Options) (CacheHelper, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	codec, err := newCodec(opts.Serializer, opts.Compression, opts.CompressionThreshold)
+	if err != nil {
+		return nil, err
+	}
+	if opts.MasterName != "" {
+		client, err := initRedisSentinel(opts)
+		if err != nil {
+			return nil, err
+		}
+		return &redisHelper{
+			client: client,
+			codec:  codec,
+		}, nil
+	}
+	if len(opts.Addrs) > 1 {
+		clusterClient, err := initRedisCluster(opts)
 		if err != nil {
-			zap.S().Panic("Failed to init redis cluster", zap.Error(err))
+			return nil, err
 		}
 		return &clusterRedisHelper{
 			clusterClient: clusterClient,
-		}
+			codec:         codec,
+		}, nil
 	}
-	client, err := initRedis(addrs[0])
+	client, err := initRedis(opts)
 	if err != nil {
-		zap.S().Panic("Failed to init redis", zap.Error(err))
+		return nil, err
 	}
 	return &redisHelper{
 		client: client,
+		codec:  codec,
+	}, nil
+}
+
+// redisClientHelper is implemented by helpers backed by a go-redis client
+type redisClientHelper interface {
+	redisClient() redis.UniversalClient
+	valueCodec() *codec
+}
+
+// wrappedHelper is implemented by decorators of another CacheHelper
+type wrappedHelper interface {
+	unwrap() CacheHelper
+}
+
+// keyMapper is implemented by decorators storing keys under other names
+type keyMapper interface {
+	key(key string) string
+}
+
+// redisClientOf returns the go-redis client behind helper, looking through decorators
+func redisClientOf(helper CacheHelper) (redis.UniversalClient, error) {
+	h, err := redisClientHelperOf(helper)
+	if err != nil {
+		return nil, err
+	}
+	return h.redisClient(), nil
+}
+
+// redisClientHelperOf returns the helper backed by redis behind helper, looking through decorators
+func redisClientHelperOf(helper CacheHelper) (redisClientHelper, error) {
+	for helper != nil {
+		switch h := helper.(type) {
+		case redisClientHelper:
+			return h, nil
+		case wrappedHelper:
+			helper = h.unwrap()
+		default:
+			return nil, ErrUnsupportedHelper
+		}
+	}
+	return nil, ErrUnsupportedHelper
+}
+
+// redisKeyOf returns the redis key helper stores key under, looking through decorators
+func redisKeyOf(helper CacheHelper, key string) string {
+	for helper != nil {
+		if h, ok := helper.(keyMapper); ok {
+			key = h.key(key)
+		}
+		h, ok := helper.(wrappedHelper)
+		if !ok {
+			break
+		}
+		helper = h.unwrap()
+	}
+	return key
+}
+
+// decodeInterface decodes into a new value of the type of value, decode receives
+// a pointer to the interface{} to fill
+func decodeInterface(value interface{}, decode func(out interface{}) error) (interface{}, error) {
+	typeValue := reflect.TypeOf(value)
+	kind := typeValue.Kind()
+
+	var outData interface{}
+	switch kind {
+	case reflect.Ptr, reflect.Struct, reflect.Slice:
+		outData = reflect.New(typeValue).Interface()
+	default:
+		outData = reflect.Zero(typeValue).Interface()
+	}
+	err := decode(&outData)
+	if err != nil {
+		return nil, err
+	}
+
+	switch kind {
+	case reflect.Ptr, reflect.Struct, reflect.Slice:
+		return reflect.ValueOf(outData).Elem().Interface(), nil
+	}
+	var outValue interface{} = outData
+	if reflect.TypeOf(outData).ConvertibleTo(typeValue) {
+		outValueConverted := reflect.ValueOf(outData).Convert(typeValue)
+		outValue = outValueConverted.Interface()
 	}
+	return outValue, nil
 }
@@ -0,0 +1,85 @@
+package cache
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	clusterSlotNumber = 16384
+
+	// clusterCursorNodeShift is the bit offset of the master identity inside a
+	// composite cluster SCAN cursor, the lower bits carry the node cursor
+	clusterCursorNodeShift = 48
+	clusterCursorNodeMask  = uint64(1)<<clusterCursorNodeShift - 1
+)
+
+// clusterSlot returns the hash slot of key following the redis cluster spec,
+// only the hash tag ({...}) is hashed when present
+func clusterSlot(key string) int {
+	return int(crc16(clusterHashTag(key)) % clusterSlotNumber)
+}
+
+func clusterHashTag(key string) string {
+	if s := strings.IndexByte(key, '{'); s > -1 {
+		if e := strings.IndexByte(key[s+1:], '}'); e > 0 {
+			return key[s+1 : s+e+1]
+		}
+	}
+	return key
+}
+
+// crc16 is the CRC16-CCITT (XMODEM) checksum used by redis cluster
+func crc16(key string) uint16 {
+	var crc uint16
+	for i := 0; i < len(key); i++ {
+		crc ^= uint16(key[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// groupKeysBySlot splits keys so that each group can be sent in a single command
+func groupKeysBySlot(keys []string) map[int][]string {
+	groups := make(map[int][]string)
+	for _, key := range keys {
+		slot := clusterSlot(key)
+		groups[slot] = append(groups[slot], key)
+	}
+	return groups
+}
+
+// clusterNodeID identifies the master at addr inside a composite cursor, it is never zero
+// so that a cursor cannot be mistaken for the start or the end of an iteration
+func clusterNodeID(addr string) uint64 {
+	return uint64(crc16(addr))%0xffff + 1
+}
+
+// encodeClusterCursor packs the identity of a master and the cursor of that master into one cursor
+func encodeClusterCursor(addr string, nodeCursor uint64) (uint64, error) {
+	if nodeCursor > clusterCursorNodeMask {
+		return 0, fmt.Errorf("cursor %d of node %s overflows cluster cursor", nodeCursor, addr)
+	}
+	return clusterNodeID(addr)<<clusterCursorNodeShift | nodeCursor, nil
+}
+
+// decodeClusterCursor is the reverse of encodeClusterCursor, it returns the position of
+// the master among masters and ErrClusterCursorNode when none of them is the one encoded
+func decodeClusterCursor(cursor uint64, masters []string) (int, uint64, error) {
+	if cursor == 0 {
+		return 0, 0, nil
+	}
+	nodeID, nodeCursor := cursor>>clusterCursorNodeShift, cursor&clusterCursorNodeMask
+	for i, addr := range masters {
+		if clusterNodeID(addr) == nodeID {
+			return i, nodeCursor, nil
+		}
+	}
+	return 0, 0, ErrClusterCursorNode
+}
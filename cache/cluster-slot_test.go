@@ -0,0 +1,55 @@
+package cache
+
+import "testing"
+
+func TestClusterSlot(t *testing.T) {
+	cases := map[string]int{
+		"123456789":            12739,
+		"foo":                  12182,
+		"bar":                  5061,
+		"{user1000}.following": clusterSlot("user1000"),
+		"{user1000}.followers": clusterSlot("user1000"),
+		"foo{}{bar}":           clusterSlot("foo{}{bar}"),
+	}
+	for key, want := range cases {
+		if got := clusterSlot(key); got != want {
+			t.Errorf("clusterSlot(%q) = %d, want %d", key, got, want)
+		}
+	}
+	if clusterHashTag("foo{}{bar}") != "foo{}{bar}" {
+		t.Errorf("empty hash tag must hash the whole key")
+	}
+}
+
+func TestClusterCursor(t *testing.T) {
+	masters := []string{"10.0.0.1:6379", "10.0.0.2:6379", "10.0.0.3:6379"}
+	cursor, err := encodeClusterCursor(masters[1], 1234)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodeIndex, nodeCursor, err := decodeClusterCursor(cursor, masters)
+	if err != nil || nodeIndex != 1 || nodeCursor != 1234 {
+		t.Errorf("decodeClusterCursor() = %d, %d, %v, want 1, 1234", nodeIndex, nodeCursor, err)
+	}
+	if nodeIndex, nodeCursor, err := decodeClusterCursor(0, masters); err != nil || nodeIndex != 0 || nodeCursor != 0 {
+		t.Errorf("decodeClusterCursor(0) = %d, %d, %v, want the start of the first master", nodeIndex, nodeCursor, err)
+	}
+	if cursor, _ := encodeClusterCursor(masters[0], 0); cursor == 0 {
+		t.Error("the cursor of the start of a master must not end the iteration")
+	}
+
+	// the master failed over to a replica with another address
+	failedOver := []string{masters[0], "10.0.0.4:6379", masters[2]}
+	if _, _, err := decodeClusterCursor(cursor, failedOver); err != ErrClusterCursorNode {
+		t.Errorf("decodeClusterCursor() after a failover = %v, want ErrClusterCursorNode", err)
+	}
+	// a master joined, the cursor still points to the same node
+	resharded := []string{"10.0.0.0:6379", masters[0], masters[1], masters[2]}
+	if nodeIndex, _, err := decodeClusterCursor(cursor, resharded); err != nil || resharded[nodeIndex] != masters[1] {
+		t.Errorf("decodeClusterCursor() after a reshard = %d, %v, want %s", nodeIndex, err, masters[1])
+	}
+
+	if _, err := encodeClusterCursor(masters[0], clusterCursorNodeMask+1); err == nil {
+		t.Errorf("expected overflow error")
+	}
+}
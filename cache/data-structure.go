@@ -0,0 +1,633 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/binpossible49/go-libs/opentracing/jaeger"
+	"github.com/go-redis/redis/v7"
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+// DataStructureHelper is helper of redis hashes, sets, sorted sets and lists.
+// Strings, numbers and booleans are stored as redis prints them so that HINCRBY and
+// the like work on them, other values go through the serializer of the helper.
+// Hash fields of a struct are named by their `redis` tag, or by the field name.
+type DataStructureHelper interface {
+	HGet(ctx context.Context, key, field string, value interface{}) error
+	HSet(ctx context.Context, key, field string, value interface{}) error
+	// HSetAll sets every field of values, a struct or a map keyed by string
+	HSetAll(ctx context.Context, key string, values interface{}) error
+	// HGetAll gets every field into values, a pointer to a struct or to a map keyed by string.
+	// Fields read into interface{} are the strings stored by redis.
+	HGetAll(ctx context.Context, key string, values interface{}) error
+	HDel(ctx context.Context, key string, fields ...string) error
+	HIncrBy(ctx context.Context, key, field string, incr int64) (int64, error)
+
+	SAdd(ctx context.Context, key string, members ...interface{}) error
+	SRem(ctx context.Context, key string, members ...interface{}) error
+	SIsMember(ctx context.Context, key string, member interface{}) (bool, error)
+	// SMembers gets the members into values, a pointer to a slice
+	SMembers(ctx context.Context, key string, values interface{}) error
+	SCard(ctx context.Context, key string) (int64, error)
+
+	ZAdd(ctx context.Context, key string, members ...ZMember) error
+	ZIncrBy(ctx context.Context, key string, incr float64, member string) (float64, error)
+	ZRangeByScore(ctx context.Context, key string, scoreRange ScoreRange) ([]ZMember, error)
+	// ZRevRange returns members from the highest score, e.g. the top of a leaderboard
+	ZRevRange(ctx context.Context, key string, start, stop int64) ([]ZMember, error)
+	ZScore(ctx context.Context, key, member string) (float64, error)
+	ZRem(ctx context.Context, key string, members ...string) error
+	ZCard(ctx context.Context, key string) (int64, error)
+
+	LPush(ctx context.Context, key string, values ...interface{}) error
+	RPush(ctx context.Context, key string, values ...interface{}) error
+	LPop(ctx context.Context, key string, value interface{}) error
+	RPop(ctx context.Context, key string, value interface{}) error
+	// BLPop waits up to timeout for an element of the first non-empty list of keys
+	// and returns the key it was popped from
+	BLPop(ctx context.Context, timeout time.Duration, value interface{}, keys ...string) (string, error)
+	BRPop(ctx context.Context, timeout time.Duration, value interface{}, keys ...string) (string, error)
+	// LRange gets elements into values, a pointer to a slice
+	LRange(ctx context.Context, key string, start, stop int64, values interface{}) error
+	LLen(ctx context.Context, key string) (int64, error)
+	LTrim(ctx context.Context, key string, start, stop int64) error
+}
+
+// ZMember is a member of a sorted set
+type ZMember struct {
+	Member string
+	Score  float64
+}
+
+// ScoreRange selects sorted set members by score, Min and Max accept redis syntax
+// such as "-inf", "+inf" or "(10" for an exclusive bound
+type ScoreRange struct {
+	Min    string
+	Max    string
+	Offset int64
+	Count  int64
+}
+
+type dataStructureHelper struct {
+	client redis.UniversalClient
+	codec  *codec
+}
+
+// NewDataStructureHelper creates an instance on the redis behind helper.
+// Only helpers created by NewCacheHelper are supported, decorators would be bypassed.
+func NewDataStructureHelper(helper CacheHelper) (DataStructureHelper, error) {
+	h, ok := helper.(redisClientHelper)
+	if !ok {
+		return nil, ErrUnsupportedHelper
+	}
+	return &dataStructureHelper{
+		client: h.redisClient(),
+		codec:  h.valueCodec(),
+	}, nil
+}
+
+func (h *dataStructureHelper) HGet(ctx context.Context, key, field string, value interface{}) (err error) {
+	span := jaeger.Start(ctx, ">helper.dataStructureHelper/HGet", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	data, err := withContext(ctx, h.client).HGet(key, field).Result()
+	if err != nil {
+		return wrapNotFound(err)
+	}
+	return h.decodeElement(data, value)
+}
+
+func (h *dataStructureHelper) HSet(ctx context.Context, key, field string, value interface{}) (err error) {
+	span := jaeger.Start(ctx, ">helper.dataStructureHelper/HSet", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	data, err := h.encodeElement(value)
+	if err != nil {
+		return err
+	}
+	return withContext(ctx, h.client).HSet(key, field, data).Err()
+}
+
+func (h *dataStructureHelper) HSetAll(ctx context.Context, key string, values interface{}) (err error) {
+	span := jaeger.Start(ctx, ">helper.dataStructureHelper/HSetAll", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	fields, err := h.hashFields(values)
+	if err != nil {
+		return err
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	return withContext(ctx, h.client).HMSet(key, fields).Err()
+}
+
+func (h *dataStructureHelper) HGetAll(ctx context.Context, key string, values interface{}) (err error) {
+	span := jaeger.Start(ctx, ">helper.dataStructureHelper/HGetAll", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	fields, err := withContext(ctx, h.client).HGetAll(key).Result()
+	if err != nil {
+		return err
+	}
+	if len(fields) == 0 {
+		return &notFoundError{}
+	}
+	return h.fillHash(fields, values)
+}
+
+func (h *dataStructureHelper) HDel(ctx context.Context, key string, fields ...string) (err error) {
+	span := jaeger.Start(ctx, ">helper.dataStructureHelper/HDel", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	return withContext(ctx, h.client).HDel(key, fields...).Err()
+}
+
+func (h *dataStructureHelper) HIncrBy(ctx context.Context, key, field string, incr int64) (result int64, err error) {
+	span := jaeger.Start(ctx, ">helper.dataStructureHelper/HIncrBy", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	return withContext(ctx, h.client).HIncrBy(key, field, incr).Result()
+}
+
+func (h *dataStructureHelper) SAdd(ctx context.Context, key string, members ...interface{}) (err error) {
+	span := jaeger.Start(ctx, ">helper.dataStructureHelper/SAdd", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	elements, err := h.encodeElements(members)
+	if err != nil {
+		return err
+	}
+	return withContext(ctx, h.client).SAdd(key, elements...).Err()
+}
+
+func (h *dataStructureHelper) SRem(ctx context.Context, key string, members ...interface{}) (err error) {
+	span := jaeger.Start(ctx, ">helper.dataStructureHelper/SRem", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	elements, err := h.encodeElements(members)
+	if err != nil {
+		return err
+	}
+	return withContext(ctx, h.client).SRem(key, elements...).Err()
+}
+
+func (h *dataStructureHelper) SIsMember(ctx context.Context, key string, member interface{}) (ok bool, err error) {
+	span := jaeger.Start(ctx, ">helper.dataStructureHelper/SIsMember", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	element, err := h.encodeElement(member)
+	if err != nil {
+		return false, err
+	}
+	return withContext(ctx, h.client).SIsMember(key, element).Result()
+}
+
+func (h *dataStructureHelper) SMembers(ctx context.Context, key string, values interface{}) (err error) {
+	span := jaeger.Start(ctx, ">helper.dataStructureHelper/SMembers", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	members, err := withContext(ctx, h.client).SMembers(key).Result()
+	if err != nil {
+		return err
+	}
+	return h.fillSlice(members, values)
+}
+
+func (h *dataStructureHelper) SCard(ctx context.Context, key string) (count int64, err error) {
+	span := jaeger.Start(ctx, ">helper.dataStructureHelper/SCard", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	return withContext(ctx, h.client).SCard(key).Result()
+}
+
+func (h *dataStructureHelper) ZAdd(ctx context.Context, key string, members ...ZMember) (err error) {
+	span := jaeger.Start(ctx, ">helper.dataStructureHelper/ZAdd", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	zs := make([]*redis.Z, len(members))
+	for i, member := range members {
+		zs[i] = &redis.Z{Score: member.Score, Member: member.Member}
+	}
+	return withContext(ctx, h.client).ZAdd(key, zs...).Err()
+}
+
+func (h *dataStructureHelper) ZIncrBy(ctx context.Context, key string, incr float64, member string) (score float64, err error) {
+	span := jaeger.Start(ctx, ">helper.dataStructureHelper/ZIncrBy", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	return withContext(ctx, h.client).ZIncrBy(key, incr, member).Result()
+}
+
+func (h *dataStructureHelper) ZRangeByScore(ctx context.Context, key string, scoreRange ScoreRange) (members []ZMember, err error) {
+	span := jaeger.Start(ctx, ">helper.dataStructureHelper/ZRangeByScore", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	zs, err := withContext(ctx, h.client).ZRangeByScoreWithScores(key, &redis.ZRangeBy{
+		Min:    scoreRange.Min,
+		Max:    scoreRange.Max,
+		Offset: scoreRange.Offset,
+		Count:  scoreRange.Count,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	return zMembers(zs), nil
+}
+
+func (h *dataStructureHelper) ZRevRange(ctx context.Context, key string, start, stop int64) (members []ZMember, err error) {
+	span := jaeger.Start(ctx, ">helper.dataStructureHelper/ZRevRange", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	zs, err := withContext(ctx, h.client).ZRevRangeWithScores(key, start, stop).Result()
+	if err != nil {
+		return nil, err
+	}
+	return zMembers(zs), nil
+}
+
+func (h *dataStructureHelper) ZScore(ctx context.Context, key, member string) (score float64, err error) {
+	span := jaeger.Start(ctx, ">helper.dataStructureHelper/ZScore", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	score, err = withContext(ctx, h.client).ZScore(key, member).Result()
+	return score, wrapNotFound(err)
+}
+
+func (h *dataStructureHelper) ZRem(ctx context.Context, key string, members ...string) (err error) {
+	span := jaeger.Start(ctx, ">helper.dataStructureHelper/ZRem", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	elements := make([]interface{}, len(members))
+	for i, member := range members {
+		elements[i] = member
+	}
+	return withContext(ctx, h.client).ZRem(key, elements...).Err()
+}
+
+func (h *dataStructureHelper) ZCard(ctx context.Context, key string) (count int64, err error) {
+	span := jaeger.Start(ctx, ">helper.dataStructureHelper/ZCard", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	return withContext(ctx, h.client).ZCard(key).Result()
+}
+
+func (h *dataStructureHelper) LPush(ctx context.Context, key string, values ...interface{}) (err error) {
+	span := jaeger.Start(ctx, ">helper.dataStructureHelper/LPush", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	elements, err := h.encodeElements(values)
+	if err != nil {
+		return err
+	}
+	return withContext(ctx, h.client).LPush(key, elements...).Err()
+}
+
+func (h *dataStructureHelper) RPush(ctx context.Context, key string, values ...interface{}) (err error) {
+	span := jaeger.Start(ctx, ">helper.dataStructureHelper/RPush", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	elements, err := h.encodeElements(values)
+	if err != nil {
+		return err
+	}
+	return withContext(ctx, h.client).RPush(key, elements...).Err()
+}
+
+func (h *dataStructureHelper) LPop(ctx context.Context, key string, value interface{}) (err error) {
+	span := jaeger.Start(ctx, ">helper.dataStructureHelper/LPop", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	data, err := withContext(ctx, h.client).LPop(key).Result()
+	if err != nil {
+		return wrapNotFound(err)
+	}
+	return h.decodeElement(data, value)
+}
+
+func (h *dataStructureHelper) RPop(ctx context.Context, key string, value interface{}) (err error) {
+	span := jaeger.Start(ctx, ">helper.dataStructureHelper/RPop", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	data, err := withContext(ctx, h.client).RPop(key).Result()
+	if err != nil {
+		return wrapNotFound(err)
+	}
+	return h.decodeElement(data, value)
+}
+
+func (h *dataStructureHelper) BLPop(ctx context.Context, timeout time.Duration, value interface{}, keys ...string) (key string, err error) {
+	span := jaeger.Start(ctx, ">helper.dataStructureHelper/BLPop", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	result, err := withContext(ctx, h.client).BLPop(timeout, keys...).Result()
+	if err != nil {
+		return "", wrapNotFound(err)
+	}
+	return result[0], h.decodeElement(result[1], value)
+}
+
+func (h *dataStructureHelper) BRPop(ctx context.Context, timeout time.Duration, value interface{}, keys ...string) (key string, err error) {
+	span := jaeger.Start(ctx, ">helper.dataStructureHelper/BRPop", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	result, err := withContext(ctx, h.client).BRPop(timeout, keys...).Result()
+	if err != nil {
+		return "", wrapNotFound(err)
+	}
+	return result[0], h.decodeElement(result[1], value)
+}
+
+func (h *dataStructureHelper) LRange(ctx context.Context, key string, start, stop int64, values interface{}) (err error) {
+	span := jaeger.Start(ctx, ">helper.dataStructureHelper/LRange", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	elements, err := withContext(ctx, h.client).LRange(key, start, stop).Result()
+	if err != nil {
+		return err
+	}
+	return h.fillSlice(elements, values)
+}
+
+func (h *dataStructureHelper) LLen(ctx context.Context, key string) (count int64, err error) {
+	span := jaeger.Start(ctx, ">helper.dataStructureHelper/LLen", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	return withContext(ctx, h.client).LLen(key).Result()
+}
+
+func (h *dataStructureHelper) LTrim(ctx context.Context, key string, start, stop int64) (err error) {
+	span := jaeger.Start(ctx, ">helper.dataStructureHelper/LTrim", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	return withContext(ctx, h.client).LTrim(key, start, stop).Err()
+}
+
+func zMembers(zs []redis.Z) []ZMember {
+	members := make([]ZMember, len(zs))
+	for i, z := range zs {
+		members[i] = ZMember{Member: fmt.Sprint(z.Member), Score: z.Score}
+	}
+	return members
+}
+
+// encodeElement returns what is stored for a hash field, a set member or a list element
+func (h *dataStructureHelper) encodeElement(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string, []byte:
+		return v, nil
+	}
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.String:
+		return rv.String(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return rv.Uint(), nil
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), nil
+	case reflect.Bool:
+		if rv.Bool() {
+			return "1", nil
+		}
+		return "0", nil
+	}
+	return h.codec.encode(value)
+}
+
+func (h *dataStructureHelper) encodeElements(values []interface{}) ([]interface{}, error) {
+	elements := make([]interface{}, len(values))
+	for i, value := range values {
+		element, err := h.encodeElement(value)
+		if err != nil {
+			return nil, err
+		}
+		elements[i] = element
+	}
+	return elements, nil
+}
+
+// decodeElement is the reverse of encodeElement, value must be a pointer
+func (h *dataStructureHelper) decodeElement(data string, value interface{}) error {
+	rv := reflect.ValueOf(value)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("cache: %T is not a non-nil pointer", value)
+	}
+	return h.decodeElementValue(data, rv.Elem())
+}
+
+func (h *dataStructureHelper) decodeElementValue(data string, target reflect.Value) error {
+	switch target.Kind() {
+	case reflect.String:
+		target.SetString(data)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(data, 10, target.Type().Bits())
+		if err != nil {
+			return err
+		}
+		target.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(data, 10, target.Type().Bits())
+		if err != nil {
+			return err
+		}
+		target.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(data, target.Type().Bits())
+		if err != nil {
+			return err
+		}
+		target.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(data)
+		if err != nil {
+			return err
+		}
+		target.SetBool(b)
+	case reflect.Slice:
+		if target.Type().Elem().Kind() == reflect.Uint8 {
+			target.SetBytes([]byte(data))
+			return nil
+		}
+		return h.codec.decode([]byte(data), target.Addr().Interface())
+	case reflect.Interface:
+		// the stored type is unknown, scalars would not go through the codec
+		if target.NumMethod() == 0 {
+			target.Set(reflect.ValueOf(data))
+			return nil
+		}
+		return h.codec.decode([]byte(data), target.Addr().Interface())
+	default:
+		return h.codec.decode([]byte(data), target.Addr().Interface())
+	}
+	return nil
+}
+
+func (h *dataStructureHelper) fillSlice(elements []string, values interface{}) error {
+	target := reflect.ValueOf(values)
+	if target.Kind() != reflect.Ptr || target.IsNil() || target.Elem().Kind() != reflect.Slice {
+		return errors.New("cache: values must be a non-nil pointer to a slice")
+	}
+	target = target.Elem()
+	result := reflect.MakeSlice(target.Type(), len(elements), len(elements))
+	for i, element := range elements {
+		if err := h.decodeElementValue(element, result.Index(i)); err != nil {
+			return err
+		}
+	}
+	target.Set(result)
+	return nil
+}
+
+// hashFields converts a struct or a map keyed by string into hash fields
+func (h *dataStructureHelper) hashFields(values interface{}) (map[string]interface{}, error) {
+	rv := reflect.Indirect(reflect.ValueOf(values))
+	fields := make(map[string]interface{})
+	switch rv.Kind() {
+	case reflect.Struct:
+		for i := 0; i < rv.NumField(); i++ {
+			name, ok := hashFieldName(rv.Type().Field(i))
+			if !ok {
+				continue
+			}
+			element, err := h.encodeElement(rv.Field(i).Interface())
+			if err != nil {
+				return nil, err
+			}
+			fields[name] = element
+		}
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return nil, errors.New("cache: hash maps must be keyed by string")
+		}
+		iter := rv.MapRange()
+		for iter.Next() {
+			element, err := h.encodeElement(iter.Value().Interface())
+			if err != nil {
+				return nil, err
+			}
+			fields[iter.Key().String()] = element
+		}
+	default:
+		return nil, fmt.Errorf("cache: %T is neither a struct nor a map", values)
+	}
+	return fields, nil
+}
+
+// fillHash is the reverse of hashFields, unknown fields are ignored
+func (h *dataStructureHelper) fillHash(fields map[string]string, values interface{}) error {
+	target := reflect.ValueOf(values)
+	if target.Kind() != reflect.Ptr || target.IsNil() {
+		return errors.New("cache: values must be a non-nil pointer to a struct or a map")
+	}
+	target = target.Elem()
+	switch target.Kind() {
+	case reflect.Struct:
+		for i := 0; i < target.NumField(); i++ {
+			name, ok := hashFieldName(target.Type().Field(i))
+			if !ok {
+				continue
+			}
+			data, ok := fields[name]
+			if !ok {
+				continue
+			}
+			if err := h.decodeElementValue(data, target.Field(i)); err != nil {
+				return fmt.Errorf("cache: field %s: %w", name, err)
+			}
+		}
+	case reflect.Map:
+		if target.Type().Key().Kind() != reflect.String {
+			return errors.New("cache: hash maps must be keyed by string")
+		}
+		if target.IsNil() {
+			target.Set(reflect.MakeMapWithSize(target.Type(), len(fields)))
+		}
+		for name, data := range fields {
+			element := reflect.New(target.Type().Elem()).Elem()
+			if err := h.decodeElementValue(data, element); err != nil {
+				return fmt.Errorf("cache: field %s: %w", name, err)
+			}
+			target.SetMapIndex(reflect.ValueOf(name).Convert(target.Type().Key()), element)
+		}
+	default:
+		return errors.New("cache: values must be a non-nil pointer to a struct or a map")
+	}
+	return nil
+}
+
+// hashFieldName returns the hash field of an exported struct field, `redis:"-"` skips it
+func hashFieldName(field reflect.StructField) (string, bool) {
+	if field.PkgPath != "" {
+		return "", false
+	}
+	tag := field.Tag.Get("redis")
+	if tag == "-" {
+		return "", false
+	}
+	if name := strings.Split(tag, ",")[0]; name != "" {
+		return name, true
+	}
+	return field.Name, true
+}
@@ -0,0 +1,192 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type profile struct {
+	Name    string `redis:"name"`
+	Age     int    `redis:"age"`
+	Active  bool
+	Tags    []string `redis:"tags"`
+	Skipped string   `redis:"-"`
+	hidden  string
+}
+
+func TestEncodeElement(t *testing.T) {
+	h := &dataStructureHelper{codec: defaultCodec}
+	type name string
+	tests := []struct {
+		value interface{}
+		want  interface{}
+	}{
+		{"text", "text"},
+		{[]byte("raw"), []byte("raw")},
+		{name("alias"), "alias"},
+		{int8(-3), int64(-3)},
+		{uint16(7), uint64(7)},
+		{float32(1.5), float64(1.5)},
+		{true, "1"},
+		{false, "0"},
+		{[]int{1, 2}, []byte(`[1,2]`)},
+		{map[string]int{"a": 1}, []byte(`{"a":1}`)},
+	}
+	for _, tt := range tests {
+		got, err := h.encodeElement(tt.value)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("encodeElement(%#v) = %#v, %v, want %#v", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestDecodeElementValue(t *testing.T) {
+	h := &dataStructureHelper{codec: defaultCodec}
+	decode := func(data string, value interface{}) interface{} {
+		t.Helper()
+		if err := h.decodeElement(data, value); err != nil {
+			t.Errorf("decodeElement(%q, %T) = %v", data, value, err)
+		}
+		return reflect.ValueOf(value).Elem().Interface()
+	}
+
+	var s string
+	var i int16
+	var u uint
+	var f float64
+	var b bool
+	var raw []byte
+	var list []int
+	var unknown interface{}
+	if got := decode("text", &s); got != "text" {
+		t.Errorf("string = %#v", got)
+	}
+	if got := decode("-12", &i); got != int16(-12) {
+		t.Errorf("int16 = %#v", got)
+	}
+	if got := decode("12", &u); got != uint(12) {
+		t.Errorf("uint = %#v", got)
+	}
+	if got := decode("2.5", &f); got != 2.5 {
+		t.Errorf("float64 = %#v", got)
+	}
+	if got := decode("1", &b); got != true {
+		t.Errorf("bool = %#v", got)
+	}
+	if got := decode("raw", &raw); string(got.([]byte)) != "raw" {
+		t.Errorf("[]byte = %#v", got)
+	}
+	if got := decode("[1,2]", &list); !reflect.DeepEqual(got, []int{1, 2}) {
+		t.Errorf("[]int = %#v", got)
+	}
+	// plain strings are not run through the codec
+	if got := decode("hello world", &unknown); got != "hello world" {
+		t.Errorf("interface{} = %#v, want the stored string", got)
+	}
+
+	if err := h.decodeElement("70000", &i); err == nil {
+		t.Error("decodeElement() overflowing int16 = nil error")
+	}
+	if err := h.decodeElement("x", s); err == nil {
+		t.Error("decodeElement() into a non-pointer = nil error")
+	}
+}
+
+func TestHashFields(t *testing.T) {
+	h := &dataStructureHelper{codec: defaultCodec}
+	fields, err := h.hashFields(&profile{Name: "a", Age: 3, Active: true, Tags: []string{"x"}, Skipped: "s", hidden: "h"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"name": "a", "age": int64(3), "Active": "1", "tags": []byte(`["x"]`)}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("hashFields(struct) = %#v, want %#v", fields, want)
+	}
+
+	fields, err = h.hashFields(map[string]int{"a": 1})
+	if err != nil || !reflect.DeepEqual(fields, map[string]interface{}{"a": int64(1)}) {
+		t.Errorf("hashFields(map) = %#v, %v", fields, err)
+	}
+	if _, err := h.hashFields(map[int]int{1: 1}); err == nil {
+		t.Error("hashFields() of a map keyed by int = nil error")
+	}
+	if _, err := h.hashFields(42); err == nil {
+		t.Error("hashFields() of an int = nil error")
+	}
+}
+
+func TestFillHash(t *testing.T) {
+	h := &dataStructureHelper{codec: defaultCodec}
+	fields := map[string]string{"name": "a", "age": "3", "Active": "1", "tags": `["x"]`, "Skipped": "s", "unknown": "u"}
+
+	var p profile
+	if err := h.fillHash(fields, &p); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(p, profile{Name: "a", Age: 3, Active: true, Tags: []string{"x"}}) {
+		t.Errorf("fillHash(struct) = %+v", p)
+	}
+
+	var m map[string]interface{}
+	if err := h.fillHash(fields, &m); err != nil {
+		t.Fatal(err)
+	}
+	if m["name"] != "a" || m["age"] != "3" || len(m) != len(fields) {
+		t.Errorf("fillHash(map[string]interface{}) = %#v, want the stored strings", m)
+	}
+
+	if err := h.fillHash(map[string]string{"age": "old"}, &p); err == nil {
+		t.Error("fillHash() of an invalid int = nil error")
+	}
+	if err := h.fillHash(fields, p); err == nil {
+		t.Error("fillHash() into a non-pointer = nil error")
+	}
+}
+
+func TestHGetAllInterfaceMap(t *testing.T) {
+	ctx := context.Background()
+	_, helper := newTestRedisHelper(t)
+	h, err := NewDataStructureHelper(helper)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.HSetAll(ctx, "user", map[string]interface{}{"name": "plain text", "visits": 3}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.HIncrBy(ctx, "user", "visits", 2); err != nil {
+		t.Fatal(err)
+	}
+	var values map[string]interface{}
+	if err := h.HGetAll(ctx, "user", &values); err != nil {
+		t.Fatal(err)
+	}
+	if values["name"] != "plain text" || values["visits"] != "5" {
+		t.Errorf("HGetAll() = %#v", values)
+	}
+}
+
+func TestDataStructureMisses(t *testing.T) {
+	ctx := context.Background()
+	_, helper := newTestRedisHelper(t)
+	h, err := NewDataStructureHelper(helper)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var value string
+	errs := map[string]error{
+		"HGet":    h.HGet(ctx, "user", "name", &value),
+		"HGetAll": h.HGetAll(ctx, "user", &map[string]string{}),
+		"LPop":    h.LPop(ctx, "queue", &value),
+		"RPop":    h.RPop(ctx, "queue", &value),
+	}
+	_, errs["ZScore"] = h.ZScore(ctx, "board", "me")
+	for method, err := range errs {
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s() of a missing key = %v, want ErrNotFound", method, err)
+		}
+	}
+}
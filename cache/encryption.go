@@ -0,0 +1,214 @@
+package cache
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/binpossible49/go-libs/opentracing/jaeger"
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+// ErrNotEncrypted is returned when reading a value that was not written by the encrypting helper
+var ErrNotEncrypted = errors.New("cache: value is not encrypted")
+
+// Keyring holds the keys of the encrypting CacheHelper
+type Keyring struct {
+	// ActiveKeyID is the key sealing new values
+	ActiveKeyID string
+	// Keys maps key IDs to AES keys of 16, 24 or 32 bytes, every key can open values
+	// so retired keys stay until the values they sealed expired
+	Keys map[string][]byte
+}
+
+// sealedValue is what the encrypting helper stores, the cache key is the additional
+// data so that a value copied under another key does not open
+type sealedValue struct {
+	KeyID string `json:"k"`
+	Nonce []byte `json:"n"`
+	Data  []byte `json:"d"`
+}
+
+type encryptedCacheHelper struct {
+	inner       CacheHelper
+	activeKeyID string
+	aeads       map[string]cipher.AEAD
+}
+
+// NewEncryptedCacheHelper creates an instance sealing values with AES-GCM before they reach inner
+func NewEncryptedCacheHelper(inner CacheHelper, keyring Keyring) (CacheHelper, error) {
+	if _, ok := keyring.Keys[keyring.ActiveKeyID]; !ok {
+		return nil, fmt.Errorf("cache: active key %q is not in the keyring", keyring.ActiveKeyID)
+	}
+	aeads := make(map[string]cipher.AEAD, len(keyring.Keys))
+	for id, key := range keyring.Keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("cache: key %q: %w", id, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("cache: key %q: %w", id, err)
+		}
+		aeads[id] = aead
+	}
+	return &encryptedCacheHelper{
+		inner:       inner,
+		activeKeyID: keyring.ActiveKeyID,
+		aeads:       aeads,
+	}, nil
+}
+
+func (h *encryptedCacheHelper) unwrap() CacheHelper {
+	return h.inner
+}
+
+func (h *encryptedCacheHelper) seal(key string, value interface{}) (*sealedValue, error) {
+	plaintext, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	aead := h.aeads[h.activeKeyID]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return &sealedValue{
+		KeyID: h.activeKeyID,
+		Nonce: nonce,
+		Data:  aead.Seal(nil, nonce, plaintext, []byte(key)),
+	}, nil
+}
+
+func (h *encryptedCacheHelper) open(ctx context.Context, key string) ([]byte, error) {
+	var sealed sealedValue
+	if err := h.inner.Get(ctx, key, &sealed); err != nil {
+		return nil, err
+	}
+	return h.openSealed(key, &sealed)
+}
+
+func (h *encryptedCacheHelper) openSealed(key string, sealed *sealedValue) ([]byte, error) {
+	if sealed.KeyID == "" {
+		return nil, ErrNotEncrypted
+	}
+	aead, ok := h.aeads[sealed.KeyID]
+	if !ok {
+		return nil, fmt.Errorf("cache: unknown key %q", sealed.KeyID)
+	}
+	if len(sealed.Nonce) != aead.NonceSize() {
+		return nil, errors.New("cache: invalid nonce")
+	}
+	return aead.Open(nil, sealed.Nonce, sealed.Data, []byte(key))
+}
+
+func (h *encryptedCacheHelper) Exists(ctx context.Context, key string) error {
+	return h.inner.Exists(ctx, key)
+}
+
+func (h *encryptedCacheHelper) Get(ctx context.Context, key string, value interface{}) (err error) {
+	span := jaeger.Start(ctx, ">helper.encryptedCacheHelper/Get", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	plaintext, err := h.open(ctx, key)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(plaintext, value)
+}
+
+func (h *encryptedCacheHelper) GetInterface(ctx context.Context, key string, value interface{}) (interface{}, error) {
+	var err error
+	span := jaeger.Start(ctx, ">helper.encryptedCacheHelper/GetInterface", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	plaintext, err := h.open(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	outValue, err := decodeInterface(value, func(out interface{}) error {
+		return json.Unmarshal(plaintext, out)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return outValue, nil
+}
+
+func (h *encryptedCacheHelper) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) (err error) {
+	span := jaeger.Start(ctx, ">helper.encryptedCacheHelper/Set", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	sealed, err := h.seal(key, value)
+	if err != nil {
+		return err
+	}
+	return h.inner.Set(ctx, key, sealed, expiration)
+}
+
+func (h *encryptedCacheHelper) Del(ctx context.Context, key string) error {
+	return h.inner.Del(ctx, key)
+}
+
+func (h *encryptedCacheHelper) Expire(ctx context.Context, key string, expiration time.Duration) error {
+	return h.inner.Expire(ctx, key, expiration)
+}
+
+func (h *encryptedCacheHelper) DelMulti(ctx context.Context, keys ...string) error {
+	return h.inner.DelMulti(ctx, keys...)
+}
+
+func (h *encryptedCacheHelper) GetKeysByPattern(ctx context.Context, pattern string, cursor uint64, limit int64) ([]string, uint64, error) {
+	return h.inner.GetKeysByPattern(ctx, pattern, cursor, limit)
+}
+
+func (h *encryptedCacheHelper) MGet(ctx context.Context, keys []string, values interface{}) (err error) {
+	span := jaeger.Start(ctx, ">helper.encryptedCacheHelper/MGet", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	var sealedValues []*sealedValue
+	if err = MGet(ctx, h.inner, keys, &sealedValues); err != nil {
+		return err
+	}
+	raws := make([][]byte, len(keys))
+	for i, sealed := range sealedValues {
+		if sealed == nil {
+			continue
+		}
+		if raws[i], err = h.openSealed(keys[i], sealed); err != nil {
+			return err
+		}
+	}
+	return fillMGetResult(keys, raws, values, json.Unmarshal)
+}
+
+func (h *encryptedCacheHelper) MSet(ctx context.Context, items ...Item) (err error) {
+	span := jaeger.Start(ctx, ">helper.encryptedCacheHelper/MSet", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	sealedItems := make([]Item, len(items))
+	for i, item := range items {
+		sealed, err := h.seal(item.Key, item.Value)
+		if err != nil {
+			return err
+		}
+		sealedItems[i] = Item{Key: item.Key, Value: sealed, Expiration: item.Expiration}
+	}
+	return MSet(ctx, h.inner, sealedItems...)
+}
@@ -0,0 +1,183 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+var (
+	testKeyA = bytes.Repeat([]byte{1}, 32)
+	testKeyB = bytes.Repeat([]byte{2}, 32)
+)
+
+func newTestEncryptedHelper(t *testing.T, inner CacheHelper, keyring Keyring) CacheHelper {
+	t.Helper()
+	helper, err := NewEncryptedCacheHelper(inner, keyring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return helper
+}
+
+type secret struct {
+	Card string
+	CVV  int
+}
+
+func TestEncryptionRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	inner := NewMemoryCacheHelper(nil)
+	helper := newTestEncryptedHelper(t, inner, Keyring{ActiveKeyID: "a", Keys: map[string][]byte{"a": testKeyA}})
+
+	want := secret{Card: "4111", CVV: 123}
+	if err := helper.Set(ctx, "card", want, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	var got secret
+	if err := helper.Get(ctx, "card", &got); err != nil || got != want {
+		t.Errorf("Get() = %+v, %v, want %+v", got, err, want)
+	}
+	value, err := helper.GetInterface(ctx, "card", secret{})
+	if err != nil || value != want {
+		t.Errorf("GetInterface() = %+v, %v, want %+v", value, err, want)
+	}
+
+	// the plaintext never reaches inner
+	var sealed sealedValue
+	if err := inner.Get(ctx, "card", &sealed); err != nil {
+		t.Fatal(err)
+	}
+	if sealed.KeyID != "a" || bytes.Contains(sealed.Data, []byte("4111")) {
+		t.Errorf("stored value = %+v, want sealed by key a", sealed)
+	}
+
+	// a value written without encryption is refused
+	inner.Set(ctx, "plain", want, time.Minute)
+	if err := helper.Get(ctx, "plain", &got); err != ErrNotEncrypted {
+		t.Errorf("Get() of a plain value = %v, want ErrNotEncrypted", err)
+	}
+}
+
+func TestEncryptionWrongKey(t *testing.T) {
+	ctx := context.Background()
+	inner := NewMemoryCacheHelper(nil)
+	writer := newTestEncryptedHelper(t, inner, Keyring{ActiveKeyID: "a", Keys: map[string][]byte{"a": testKeyA}})
+	// same key ID, different key material
+	reader := newTestEncryptedHelper(t, inner, Keyring{ActiveKeyID: "a", Keys: map[string][]byte{"a": testKeyB}})
+	// key ID unknown to the reader
+	other := newTestEncryptedHelper(t, inner, Keyring{ActiveKeyID: "b", Keys: map[string][]byte{"b": testKeyB}})
+
+	if err := writer.Set(ctx, "card", secret{Card: "4111"}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	var got secret
+	if err := reader.Get(ctx, "card", &got); err == nil {
+		t.Errorf("Get() with the wrong key = %+v, want an error", got)
+	}
+	if err := other.Get(ctx, "card", &got); err == nil {
+		t.Errorf("Get() with an unknown key ID = %+v, want an error", got)
+	}
+}
+
+func TestEncryptionKeyRotation(t *testing.T) {
+	ctx := context.Background()
+	inner := NewMemoryCacheHelper(nil)
+	before := newTestEncryptedHelper(t, inner, Keyring{ActiveKeyID: "a", Keys: map[string][]byte{"a": testKeyA}})
+	after := newTestEncryptedHelper(t, inner, Keyring{ActiveKeyID: "b", Keys: map[string][]byte{"a": testKeyA, "b": testKeyB}})
+
+	if err := before.Set(ctx, "old", secret{Card: "old"}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	var got secret
+	if err := after.Get(ctx, "old", &got); err != nil || got.Card != "old" {
+		t.Errorf("Get() of a value sealed by the retired key = %+v, %v", got, err)
+	}
+
+	if err := after.Set(ctx, "new", secret{Card: "new"}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	var sealed sealedValue
+	inner.Get(ctx, "new", &sealed)
+	if sealed.KeyID != "b" {
+		t.Errorf("new value sealed by key %q, want the active key b", sealed.KeyID)
+	}
+	if err := before.Get(ctx, "new", &got); err == nil {
+		t.Error("Get() of a value sealed by a key missing from the keyring = nil error")
+	}
+}
+
+func TestEncryptionBindsCacheKey(t *testing.T) {
+	ctx := context.Background()
+	inner := NewMemoryCacheHelper(nil)
+	helper := newTestEncryptedHelper(t, inner, Keyring{ActiveKeyID: "a", Keys: map[string][]byte{"a": testKeyA}})
+
+	if err := helper.Set(ctx, "user:1:card", secret{Card: "4111"}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	var sealed sealedValue
+	inner.Get(ctx, "user:1:card", &sealed)
+	inner.Set(ctx, "user:2:card", sealed, time.Minute)
+
+	var got secret
+	if err := helper.Get(ctx, "user:2:card", &got); err == nil {
+		t.Errorf("Get() of a value copied under another key = %+v, want an error", got)
+	}
+}
+
+func TestEncryptionTamper(t *testing.T) {
+	ctx := context.Background()
+	inner := NewMemoryCacheHelper(nil)
+	helper := newTestEncryptedHelper(t, inner, Keyring{ActiveKeyID: "a", Keys: map[string][]byte{"a": testKeyA}})
+
+	if err := helper.Set(ctx, "card", secret{Card: "4111"}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	var sealed sealedValue
+	inner.Get(ctx, "card", &sealed)
+
+	tampered := sealed
+	tampered.Data = append([]byte(nil), sealed.Data...)
+	tampered.Data[0] ^= 1
+	inner.Set(ctx, "card", tampered, time.Minute)
+	var got secret
+	if err := helper.Get(ctx, "card", &got); err == nil {
+		t.Error("Get() of a tampered ciphertext = nil error")
+	}
+
+	tampered = sealed
+	tampered.Nonce = sealed.Nonce[:4]
+	inner.Set(ctx, "card", tampered, time.Minute)
+	if err := helper.Get(ctx, "card", &got); err == nil {
+		t.Error("Get() with a truncated nonce = nil error")
+	}
+}
+
+func TestNewEncryptedCacheHelperValidation(t *testing.T) {
+	inner := NewMemoryCacheHelper(nil)
+	if _, err := NewEncryptedCacheHelper(inner, Keyring{ActiveKeyID: "a", Keys: map[string][]byte{"b": testKeyB}}); err == nil {
+		t.Error("NewEncryptedCacheHelper() without the active key = nil error")
+	}
+	if _, err := NewEncryptedCacheHelper(inner, Keyring{ActiveKeyID: "a", Keys: map[string][]byte{"a": []byte("short")}}); err == nil {
+		t.Error("NewEncryptedCacheHelper() with an invalid key size = nil error")
+	}
+}
+
+func TestDecodeInterface(t *testing.T) {
+	// the three helpers share decodeInterface, it returns values of the type of the example
+	value, err := decodeInterface(secret{}, func(out interface{}) error {
+		return json.Unmarshal([]byte(`{"Card":"4111"}`), out)
+	})
+	if err != nil || value != (secret{Card: "4111"}) {
+		t.Errorf("decodeInterface(struct) = %+v, %v", value, err)
+	}
+
+	value, err = decodeInterface(int64(0), func(out interface{}) error {
+		return json.Unmarshal([]byte(`42`), out)
+	})
+	if err != nil || value != int64(42) {
+		t.Errorf("decodeInterface(int64) = %#v, %v, want int64(42)", value, err)
+	}
+}
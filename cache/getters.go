@@ -0,0 +1,47 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"reflect"
+)
+
+// ErrInvalidTarget is returned when the value to decode into is not a non-nil pointer
+var ErrInvalidTarget = errors.New("cache: value must be a non-nil pointer")
+
+// GetString gets key stored as a string
+func GetString(ctx context.Context, helper CacheHelper, key string) (string, error) {
+	var value string
+	if err := helper.Get(ctx, key, &value); err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
+// GetInt64 gets key stored as an integer
+func GetInt64(ctx context.Context, helper CacheHelper, key string) (int64, error) {
+	var value int64
+	if err := helper.Get(ctx, key, &value); err != nil {
+		return 0, err
+	}
+	return value, nil
+}
+
+// GetBytes gets key stored as a []byte
+func GetBytes(ctx context.Context, helper CacheHelper, key string) ([]byte, error) {
+	var value []byte
+	if err := helper.Get(ctx, key, &value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// GetInto gets key into value, which must be a non-nil pointer, it replaces GetInterface
+// by letting the caller choose the type instead of guessing it
+func GetInto(ctx context.Context, helper CacheHelper, key string, value interface{}) error {
+	target := reflect.ValueOf(value)
+	if target.Kind() != reflect.Ptr || target.IsNil() {
+		return ErrInvalidTarget
+	}
+	return helper.Get(ctx, key, value)
+}
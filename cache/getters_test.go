@@ -0,0 +1,62 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/go-redis/redis/v7"
+)
+
+func TestNotFound(t *testing.T) {
+	ctx := context.Background()
+	server, redisHelper := newTestRedisHelper(t)
+	server.Set("other", "1")
+	helpers := map[string]CacheHelper{
+		"redis":  redisHelper,
+		"memory": NewMemoryCacheHelper(nil),
+	}
+	for name, h := range helpers {
+		var value string
+		errs := map[string]error{"Get": h.Get(ctx, "missing", &value), "Exists": h.Exists(ctx, "missing")}
+		_, errs["GetInterface"] = h.GetInterface(ctx, "missing", "")
+		for method, err := range errs {
+			// callers written against go-redis keep matching redis.Nil
+			if !errors.Is(err, ErrNotFound) || !errors.Is(err, redis.Nil) || err.Error() != "cache: not found" {
+				t.Errorf("%s: %s() of a missing key = %v, want ErrNotFound matching redis.Nil", name, method, err)
+			}
+		}
+	}
+	if err := redisHelper.Get(ctx, "missing", new(string)); errors.Unwrap(err) != redis.Nil {
+		t.Errorf("cause of a redis miss = %v, want redis.Nil", errors.Unwrap(err))
+	}
+}
+
+func TestTypedGetters(t *testing.T) {
+	ctx := context.Background()
+	h := NewMemoryCacheHelper(nil)
+	h.Set(ctx, "string", "value", 0)
+	h.Set(ctx, "int", 42, 0)
+	h.Set(ctx, "bytes", []byte{1, 2, 3}, 0)
+
+	if value, err := GetString(ctx, h, "string"); err != nil || value != "value" {
+		t.Errorf("GetString() = %q, %v", value, err)
+	}
+	if value, err := GetInt64(ctx, h, "int"); err != nil || value != 42 {
+		t.Errorf("GetInt64() = %d, %v", value, err)
+	}
+	if value, err := GetBytes(ctx, h, "bytes"); err != nil || string(value) != "\x01\x02\x03" {
+		t.Errorf("GetBytes() = %v, %v", value, err)
+	}
+	if _, err := GetString(ctx, h, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetString(missing) = %v, want ErrNotFound", err)
+	}
+
+	var value int
+	if err := GetInto(ctx, h, "int", value); err != ErrInvalidTarget {
+		t.Errorf("GetInto(non-pointer) = %v, want ErrInvalidTarget", err)
+	}
+	if err := GetInto(ctx, h, "int", &value); err != nil || value != 42 {
+		t.Errorf("GetInto() = %d, %v", value, err)
+	}
+}
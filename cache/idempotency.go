@@ -0,0 +1,186 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/binpossible49/go-libs/opentracing/jaeger"
+	"github.com/go-redis/redis/v7"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultIdempotencyKeyPrefix   = "idempotency:"
+	defaultIdempotencyLockTTL     = time.Minute
+	defaultIdempotencyResponseTTL = 24 * time.Hour
+	// idempotencyFinishTimeout bounds storing the response or releasing the key, which
+	// happen once fn ran even when the caller gave up meanwhile
+	idempotencyFinishTimeout = 5 * time.Second
+)
+
+var (
+	// ErrIdempotencyConflict is returned while another request with the same key is in progress
+	ErrIdempotencyConflict = errors.New("cache: request with the same idempotency key is in progress")
+	// ErrIdempotencyMismatch is returned when a key is reused for a different request
+	ErrIdempotencyMismatch = errors.New("cache: idempotency key reused with a different request")
+	// ErrIdempotencyExpired is returned when the request outlived LockTTL, its response was
+	// not stored since a duplicate may have run meanwhile
+	ErrIdempotencyExpired = errors.New("cache: idempotency key expired before the response was stored")
+)
+
+var (
+	// claimIdempotencyScript stores the in-progress entry unless the key exists,
+	// the existing entry is returned so that claiming and reading are atomic
+	claimIdempotencyScript = newBuiltinScript("idempotency.claim", `
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return false
+end
+return redis.call("GET", KEYS[1])`)
+	// completeIdempotencyScript replaces our in-progress entry with the response
+	completeIdempotencyScript = newBuiltinScript("idempotency.complete", `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	redis.call("SET", KEYS[1], ARGV[2], "PX", ARGV[3])
+	return 1
+end
+return 0`)
+	// abortIdempotencyScript removes our in-progress entry so that a retry can run
+	abortIdempotencyScript = newBuiltinScript("idempotency.abort", `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+)
+
+// IdempotencyStore deduplicates requests carrying the same idempotency key
+type IdempotencyStore interface {
+	// Do runs fn once per key and stores its result into response, replays get the stored
+	// response without running fn. A failed fn is not stored so that the request can be retried.
+	// fingerprint identifies the request (e.g. a hash of its body), a key reused with another
+	// fingerprint fails with ErrIdempotencyMismatch, empty fingerprints are not compared.
+	// When fn succeeded but its response could not be stored, response is decoded and the
+	// error is returned, a retry would run fn again.
+	Do(ctx context.Context, key, fingerprint string, response interface{}, fn func(ctx context.Context) (interface{}, error)) (replayed bool, err error)
+}
+
+// IdempotencyOptions represents options of IdempotencyStore
+type IdempotencyOptions struct {
+	// KeyPrefix is prepended to idempotency keys
+	KeyPrefix string
+	// LockTTL bounds how long a request stays in progress, it must exceed the duration of fn
+	// or a duplicate may run once it expired
+	LockTTL time.Duration
+	// ResponseTTL is how long responses are replayed
+	ResponseTTL time.Duration
+}
+
+// idempotencyEntry is stored under the key, Token is set while in progress and Response once done
+type idempotencyEntry struct {
+	Fingerprint string          `json:"f,omitempty"`
+	Token       string          `json:"t,omitempty"`
+	Response    json.RawMessage `json:"r,omitempty"`
+}
+
+type idempotencyStore struct {
+	client redis.UniversalClient
+	opts   IdempotencyOptions
+}
+
+// NewIdempotencyStore creates an instance on the redis behind helper
+func NewIdempotencyStore(helper CacheHelper, opts IdempotencyOptions) (IdempotencyStore, error) {
+	client, err := redisClientOf(helper)
+	if err != nil {
+		return nil, err
+	}
+	if opts.KeyPrefix == "" {
+		opts.KeyPrefix = defaultIdempotencyKeyPrefix
+	}
+	if opts.LockTTL <= 0 {
+		opts.LockTTL = defaultIdempotencyLockTTL
+	}
+	if opts.ResponseTTL <= 0 {
+		opts.ResponseTTL = defaultIdempotencyResponseTTL
+	}
+	return &idempotencyStore{client: client, opts: opts}, nil
+}
+
+func (s *idempotencyStore) Do(ctx context.Context, key, fingerprint string, response interface{}, fn func(ctx context.Context) (interface{}, error)) (replayed bool, err error) {
+	span := jaeger.Start(ctx, ">helper.idempotencyStore/Do", ext.SpanKindRPCClient, opentracing.Tag{Key: "idempotency.key", Value: key})
+	defer func() {
+		span.SetTag("idempotency.replayed", replayed)
+		jaeger.Finish(span, err)
+	}()
+
+	token, err := randomToken()
+	if err != nil {
+		return false, err
+	}
+	marker, err := json.Marshal(idempotencyEntry{Fingerprint: fingerprint, Token: token})
+	if err != nil {
+		return false, err
+	}
+
+	redisKey := s.opts.KeyPrefix + key
+	client := withContext(ctx, s.client)
+	existing, err := claimIdempotencyScript.Run(client, []string{redisKey}, string(marker), s.opts.LockTTL.Milliseconds()).Text()
+	switch {
+	case err == nil:
+		return s.replay(existing, fingerprint, response)
+	case err != redis.Nil:
+		return false, err
+	}
+
+	// the key is released or completed even when ctx is done by the time fn returns
+	finishCtx, cancel := context.WithTimeout(detach(ctx), idempotencyFinishTimeout)
+	defer cancel()
+
+	// a response that cannot be encoded is not stored either, the key is released for a retry
+	var data, done []byte
+	result, err := fn(ctx)
+	if err == nil {
+		data, err = json.Marshal(result)
+	}
+	if err == nil {
+		done, err = json.Marshal(idempotencyEntry{Fingerprint: fingerprint, Response: data})
+	}
+	if err == nil {
+		err = json.Unmarshal(data, response)
+	}
+	if err != nil {
+		abortErr := abortIdempotencyScript.Run(withContext(finishCtx, s.client), []string{redisKey}, string(marker)).Err()
+		if abortErr != nil {
+			zap.S().Warnw("Failed to release idempotency key", "key", key, zap.Error(abortErr))
+		}
+		return false, err
+	}
+
+	stored, err := completeIdempotencyScript.Run(withContext(finishCtx, s.client), []string{redisKey}, string(marker), string(done), s.opts.ResponseTTL.Milliseconds()).Int64()
+	if err != nil {
+		return false, err
+	}
+	if stored == 0 {
+		return false, ErrIdempotencyExpired
+	}
+	return false, nil
+}
+
+// replay decodes a completed entry into response, it is replayed only once decoded
+func (s *idempotencyStore) replay(data, fingerprint string, response interface{}) (bool, error) {
+	var entry idempotencyEntry
+	if err := json.Unmarshal([]byte(data), &entry); err != nil {
+		return false, err
+	}
+	if fingerprint != "" && entry.Fingerprint != "" && entry.Fingerprint != fingerprint {
+		return false, ErrIdempotencyMismatch
+	}
+	if entry.Token != "" {
+		return false, ErrIdempotencyConflict
+	}
+	if err := json.Unmarshal(entry.Response, response); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,183 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type idempotentResponse struct {
+	ID int `json:"id"`
+}
+
+func newTestIdempotencyStore(t *testing.T) (IdempotencyStore, func(time.Duration)) {
+	t.Helper()
+	server, helper := newTestRedisHelper(t)
+	store, err := NewIdempotencyStore(helper, IdempotencyOptions{LockTTL: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, server.FastForward
+}
+
+func TestIdempotencyReplay(t *testing.T) {
+	ctx := context.Background()
+	store, _ := newTestIdempotencyStore(t)
+	calls := 0
+	fn := func(ctx context.Context) (interface{}, error) {
+		calls++
+		return idempotentResponse{ID: calls}, nil
+	}
+
+	var first, second idempotentResponse
+	replayed, err := store.Do(ctx, "req", "body", &first, fn)
+	if err != nil || replayed || first.ID != 1 {
+		t.Fatalf("Do() = %v, %v, response %v, want fn to run", replayed, err, first)
+	}
+	replayed, err = store.Do(ctx, "req", "body", &second, fn)
+	if err != nil || !replayed || second.ID != 1 || calls != 1 {
+		t.Errorf("Do() again = %v, %v, response %v, want the stored response replayed", replayed, err, second)
+	}
+}
+
+func TestIdempotencyFailureIsRetried(t *testing.T) {
+	ctx := context.Background()
+	store, _ := newTestIdempotencyStore(t)
+	failure := errors.New("failed")
+	var response idempotentResponse
+	if _, err := store.Do(ctx, "req", "", &response, func(ctx context.Context) (interface{}, error) {
+		return nil, failure
+	}); err != failure {
+		t.Fatalf("Do() = %v, want the error of fn", err)
+	}
+	replayed, err := store.Do(ctx, "req", "", &response, func(ctx context.Context) (interface{}, error) {
+		return idempotentResponse{ID: 2}, nil
+	})
+	if err != nil || replayed || response.ID != 2 {
+		t.Errorf("Do() after a failure = %v, %v, response %v, want fn to run again", replayed, err, response)
+	}
+}
+
+func TestIdempotencyConflict(t *testing.T) {
+	ctx := context.Background()
+	store, _ := newTestIdempotencyStore(t)
+	running, release := make(chan struct{}), make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		var response idempotentResponse
+		_, err := store.Do(ctx, "req", "body", &response, func(ctx context.Context) (interface{}, error) {
+			close(running)
+			<-release
+			return idempotentResponse{ID: 1}, nil
+		})
+		done <- err
+	}()
+	<-running
+
+	var response idempotentResponse
+	replayed, err := store.Do(ctx, "req", "body", &response, func(ctx context.Context) (interface{}, error) {
+		t.Error("fn of a duplicate should not run")
+		return nil, nil
+	})
+	if err != ErrIdempotencyConflict || replayed {
+		t.Errorf("Do() while in progress = %v, %v, want false, ErrIdempotencyConflict", replayed, err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Errorf("Do() in progress = %v", err)
+	}
+}
+
+func TestIdempotencyMismatch(t *testing.T) {
+	ctx := context.Background()
+	store, _ := newTestIdempotencyStore(t)
+	var response idempotentResponse
+	store.Do(ctx, "req", "body", &response, func(ctx context.Context) (interface{}, error) {
+		return idempotentResponse{ID: 1}, nil
+	})
+
+	response = idempotentResponse{}
+	replayed, err := store.Do(ctx, "req", "other body", &response, func(ctx context.Context) (interface{}, error) {
+		t.Error("fn of a mismatched request should not run")
+		return nil, nil
+	})
+	if err != ErrIdempotencyMismatch || replayed || response.ID != 0 {
+		t.Errorf("Do() with another fingerprint = %v, %v, response %v, want false, ErrIdempotencyMismatch", replayed, err, response)
+	}
+}
+
+func TestIdempotencyCompletesAfterCancel(t *testing.T) {
+	store, _ := newTestIdempotencyStore(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	var response idempotentResponse
+	_, err := store.Do(ctx, "req", "", &response, func(ctx context.Context) (interface{}, error) {
+		// the caller gives up while fn runs
+		cancel()
+		return idempotentResponse{ID: 1}, nil
+	})
+	if err != nil {
+		t.Fatalf("Do() canceled during fn = %v, want the response stored", err)
+	}
+
+	replayed, err := store.Do(context.Background(), "req", "", &response, func(ctx context.Context) (interface{}, error) {
+		t.Error("fn should not run again")
+		return nil, nil
+	})
+	if err != nil || !replayed || response.ID != 1 {
+		t.Errorf("Do() after a canceled caller = %v, %v, response %v, want a replay", replayed, err, response)
+	}
+
+	// a failure canceled by the caller still releases the key
+	ctx, cancel = context.WithCancel(context.Background())
+	failure := errors.New("failed")
+	store.Do(ctx, "other", "", &response, func(ctx context.Context) (interface{}, error) {
+		cancel()
+		return nil, failure
+	})
+	replayed, err = store.Do(context.Background(), "other", "", &response, func(ctx context.Context) (interface{}, error) {
+		return idempotentResponse{ID: 2}, nil
+	})
+	if err != nil || replayed || response.ID != 2 {
+		t.Errorf("Do() after a canceled failure = %v, %v, response %v, want fn to run", replayed, err, response)
+	}
+}
+
+func TestIdempotencyExpired(t *testing.T) {
+	store, fastForward := newTestIdempotencyStore(t)
+	var response idempotentResponse
+	replayed, err := store.Do(context.Background(), "req", "", &response, func(ctx context.Context) (interface{}, error) {
+		// fn outlives LockTTL
+		fastForward(2 * time.Second)
+		return idempotentResponse{ID: 1}, nil
+	})
+	if err != ErrIdempotencyExpired || replayed || response.ID != 1 {
+		t.Errorf("Do() past LockTTL = %v, %v, response %v, want the response and ErrIdempotencyExpired", replayed, err, response)
+	}
+}
+
+func TestIdempotencyEncodingFailureIsRetried(t *testing.T) {
+	ctx := context.Background()
+	store, _ := newTestIdempotencyStore(t)
+	var response idempotentResponse
+	if _, err := store.Do(ctx, "req", "", &response, func(ctx context.Context) (interface{}, error) {
+		// channels cannot be encoded
+		return make(chan int), nil
+	}); err == nil {
+		t.Fatal("Do() with a response that cannot be encoded = nil, want an error")
+	}
+	if _, err := store.Do(ctx, "other", "", &response, func(ctx context.Context) (interface{}, error) {
+		return "not an object", nil
+	}); err == nil {
+		t.Fatal("Do() with a response that does not decode = nil, want an error")
+	}
+
+	for _, key := range []string{"req", "other"} {
+		replayed, err := store.Do(ctx, key, "", &response, func(ctx context.Context) (interface{}, error) {
+			return idempotentResponse{ID: 2}, nil
+		})
+		if err != nil || replayed || response.ID != 2 {
+			t.Errorf("Do(%q) after an encoding failure = %v, %v, response %v, want fn to run again", key, replayed, err, response)
+		}
+	}
+}
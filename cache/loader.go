@@ -0,0 +1,227 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math"
+	mathrand "math/rand"
+	"time"
+
+	"github.com/binpossible49/go-libs/opentracing/jaeger"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	defaultLoadLockRetryInterval = 50 * time.Millisecond
+	loadLockSuffix               = ":load-lock"
+)
+
+// releaseLoadLockScript deletes the lock only when it is still held by the caller
+var releaseLoadLockScript = newBuiltinScript("loader.releaseLock", `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// LoaderFunc loads the value of a key on cache miss
+type LoaderFunc func(ctx context.Context) (interface{}, error)
+
+// LoaderOptions represents options of Loader
+type LoaderOptions struct {
+	// LockTTL enables a short redis lock so that only one instance loads a key, 0 disables it
+	LockTTL time.Duration
+	// LockRetryInterval is how often instances waiting for the lock holder look for the value
+	LockRetryInterval time.Duration
+	// EarlyRefreshBeta enables probabilistic early refresh before expiry, 1 is a good start
+	// and larger values refresh earlier, 0 disables it
+	EarlyRefreshBeta float64
+}
+
+// Loader implements cache-aside reads with stampede protection
+type Loader struct {
+	helper CacheHelper
+	opts   LoaderOptions
+	// group coalesces loads on miss and refreshGroup early refreshes, a refresh gives up
+	// when another instance holds the lock which callers on miss must not see
+	group        singleflight.Group
+	refreshGroup singleflight.Group
+}
+
+// loadedEntry is what the Loader stores, Delta and Expiry drive the early refresh
+type loadedEntry struct {
+	Value  json.RawMessage `json:"v"`
+	Delta  int64           `json:"d"`
+	Expiry int64           `json:"e"`
+}
+
+// NewLoader creates an instance, keys written by the Loader must be read through it
+func NewLoader(helper CacheHelper, opts LoaderOptions) *Loader {
+	if opts.LockRetryInterval <= 0 {
+		opts.LockRetryInterval = defaultLoadLockRetryInterval
+	}
+	return &Loader{
+		helper: helper,
+		opts:   opts,
+	}
+}
+
+// GetOrLoad gets key into value, on miss the loader is called once per key per process
+// and its result is stored with ttl. The load outlives a caller giving up on ctx so that
+// the other callers waiting for it still get the value.
+func (l *Loader) GetOrLoad(ctx context.Context, key string, ttl time.Duration, value interface{}, loader LoaderFunc) (err error) {
+	span := jaeger.Start(ctx, ">helper.Loader/GetOrLoad", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+	ctx = opentracing.ContextWithSpan(ctx, span)
+
+	var entry loadedEntry
+	err = l.helper.Get(ctx, key, &entry)
+	switch {
+	case err == nil:
+		if l.shouldRefresh(entry) {
+			go l.refresh(detach(ctx), key, ttl, loader)
+		}
+		return json.Unmarshal(entry.Value, value)
+	case !errors.Is(err, ErrNotFound):
+		return err
+	}
+
+	result := l.group.DoChan(key, func() (interface{}, error) {
+		return l.load(detach(ctx), key, ttl, loader, true)
+	})
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case res := <-result:
+		if res.Err != nil {
+			return res.Err
+		}
+		return json.Unmarshal(res.Val.([]byte), value)
+	}
+}
+
+// shouldRefresh implements the XFetch algorithm: the closer to expiry and the slower
+// the loader, the more likely a caller refreshes the entry
+func (l *Loader) shouldRefresh(entry loadedEntry) bool {
+	if l.opts.EarlyRefreshBeta <= 0 || entry.Expiry == 0 {
+		return false
+	}
+	delta := float64(entry.Delta) * l.opts.EarlyRefreshBeta * -math.Log(mathrand.Float64())
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	return float64(now)+delta >= float64(entry.Expiry)
+}
+
+// refresh reloads key ahead of expiry, it is skipped while another instance loads it
+func (l *Loader) refresh(ctx context.Context, key string, ttl time.Duration, loader LoaderFunc) {
+	var err error
+	span := jaeger.Start(ctx, ">helper.Loader/refresh", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	_, err, _ = l.refreshGroup.Do(key, func() (interface{}, error) {
+		return l.load(opentracing.ContextWithSpan(ctx, span), key, ttl, loader, false)
+	})
+	if err != nil {
+		zap.S().Warnw("Failed to refresh cache entry", "key", key, zap.Error(err))
+	}
+}
+
+// load loads and stores key, wait tells whether to wait for another instance holding
+// the lock instead of giving up with nil data
+func (l *Loader) load(ctx context.Context, key string, ttl time.Duration, loader LoaderFunc, wait bool) ([]byte, error) {
+	if l.opts.LockTTL <= 0 {
+		return l.loadAndStore(ctx, key, ttl, loader)
+	}
+	client, err := redisClientOf(l.helper)
+	if err != nil {
+		return nil, err
+	}
+
+	lockKey := key + loadLockSuffix
+	token, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	acquired, err := withContext(ctx, client).SetNX(lockKey, token, l.opts.LockTTL).Result()
+	if err != nil {
+		return nil, err
+	}
+	if !acquired {
+		if !wait {
+			return nil, nil
+		}
+		if data, ok := l.waitForValue(ctx, key); ok {
+			return data, nil
+		}
+		return l.loadAndStore(ctx, key, ttl, loader)
+	}
+	defer func() {
+		if err := releaseLoadLockScript.Run(withContext(ctx, client), []string{lockKey}, token).Err(); err != nil {
+			zap.S().Warnw("Failed to release load lock", "key", key, zap.Error(err))
+		}
+	}()
+	return l.loadAndStore(ctx, key, ttl, loader)
+}
+
+// waitForValue polls key while another instance loads it, up to the lock TTL
+func (l *Loader) waitForValue(ctx context.Context, key string) ([]byte, bool) {
+	timer := time.NewTimer(l.opts.LockTTL)
+	defer timer.Stop()
+	ticker := time.NewTicker(l.opts.LockRetryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, false
+		case <-timer.C:
+			return nil, false
+		case <-ticker.C:
+			var entry loadedEntry
+			if err := l.helper.Get(ctx, key, &entry); err == nil {
+				return entry.Value, true
+			}
+		}
+	}
+}
+
+func (l *Loader) loadAndStore(ctx context.Context, key string, ttl time.Duration, loader LoaderFunc) ([]byte, error) {
+	start := time.Now()
+	value, err := loader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	entry := loadedEntry{
+		Value: data,
+		Delta: int64(now.Sub(start) / time.Millisecond),
+	}
+	if ttl > 0 {
+		entry.Expiry = now.Add(ttl).UnixNano() / int64(time.Millisecond)
+	}
+	if err := l.helper.Set(ctx, key, entry, ttl); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
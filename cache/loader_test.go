@@ -0,0 +1,184 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLoaderHitAndMiss(t *testing.T) {
+	ctx := context.Background()
+	l := NewLoader(NewMemoryCacheHelper(nil), LoaderOptions{})
+	var calls int32
+	loader := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		return "value", nil
+	}
+
+	for i := 0; i < 2; i++ {
+		var value string
+		if err := l.GetOrLoad(ctx, "key", time.Minute, &value, loader); err != nil || value != "value" {
+			t.Errorf("GetOrLoad() = %q, %v", value, err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("loader calls = %d, want 1 as the second call hits", calls)
+	}
+}
+
+func TestLoaderCoalescing(t *testing.T) {
+	ctx := context.Background()
+	l := NewLoader(NewMemoryCacheHelper(nil), LoaderOptions{})
+	var calls int32
+	release := make(chan struct{})
+	loader := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return 42, nil
+	}
+
+	var wg sync.WaitGroup
+	results := make([]int, 5)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := l.GetOrLoad(ctx, "key", time.Minute, &results[i], loader); err != nil {
+				t.Errorf("GetOrLoad() = %v", err)
+			}
+		}(i)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("loader calls = %d, want concurrent misses coalesced", calls)
+	}
+	for _, result := range results {
+		if result != 42 {
+			t.Errorf("GetOrLoad() = %d, want 42", result)
+		}
+	}
+}
+
+func TestLoaderCanceledCallerDoesNotFailOthers(t *testing.T) {
+	l := NewLoader(NewMemoryCacheHelper(nil), LoaderOptions{})
+	release := make(chan struct{})
+	loader := func(ctx context.Context) (interface{}, error) {
+		<-release
+		return "value", ctx.Err()
+	}
+
+	first, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		var value string
+		done <- l.GetOrLoad(first, "key", time.Minute, &value, loader)
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	var value string
+	second := make(chan error)
+	go func() {
+		second <- l.GetOrLoad(context.Background(), "key", time.Minute, &value, loader)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("GetOrLoad() of the canceled caller = %v, want context.Canceled", err)
+	}
+	close(release)
+	if err := <-second; err != nil || value != "value" {
+		t.Errorf("GetOrLoad() of the waiting caller = %q, %v", value, err)
+	}
+}
+
+func TestLoaderLockContention(t *testing.T) {
+	ctx := context.Background()
+	server, helper := newTestRedisHelper(t)
+	l := NewLoader(helper, LoaderOptions{LockTTL: time.Second, LockRetryInterval: 5 * time.Millisecond})
+	var calls int32
+	loader := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		return "mine", nil
+	}
+
+	// another instance holds the lock, a refresh gives up without error
+	server.Set("key"+loadLockSuffix, "other")
+	if data, err := l.load(ctx, "key", time.Minute, loader, false); data != nil || err != nil {
+		t.Errorf("load() without waiting = %q, %v, want nil", data, err)
+	}
+
+	// a miss waits for the value stored by the lock holder
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		data, _ := json.Marshal(loadedEntry{Value: json.RawMessage(`"theirs"`)})
+		server.Set("key", string(data))
+	}()
+	var value string
+	if err := l.GetOrLoad(ctx, "key", time.Minute, &value, loader); err != nil || value != "theirs" {
+		t.Errorf("GetOrLoad() = %q, %v, want the value of the lock holder", value, err)
+	}
+	if calls != 0 {
+		t.Errorf("loader calls = %d, want 0 while another instance loads", calls)
+	}
+
+	// the lock is taken and released by the loading instance
+	server.Del("key")
+	server.Del("key" + loadLockSuffix)
+	if err := l.GetOrLoad(ctx, "key", time.Minute, &value, loader); err != nil || value != "mine" {
+		t.Errorf("GetOrLoad() = %q, %v", value, err)
+	}
+	if server.Exists("key" + loadLockSuffix) {
+		t.Error("load lock should be released")
+	}
+}
+
+func TestLoaderShouldRefresh(t *testing.T) {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	l := NewLoader(nil, LoaderOptions{EarlyRefreshBeta: 1})
+
+	if l.shouldRefresh(loadedEntry{Delta: 10, Expiry: now - 1}) != true {
+		t.Error("shouldRefresh() of an expired entry = false")
+	}
+	if l.shouldRefresh(loadedEntry{Delta: 1, Expiry: now + int64(time.Hour/time.Millisecond)}) {
+		t.Error("shouldRefresh() an hour before expiry of a fast loader = true")
+	}
+	if l.shouldRefresh(loadedEntry{Delta: 10}) {
+		t.Error("shouldRefresh() of an entry without expiry = true")
+	}
+	if NewLoader(nil, LoaderOptions{}).shouldRefresh(loadedEntry{Delta: 10, Expiry: now - 1}) {
+		t.Error("shouldRefresh() with early refresh disabled = true")
+	}
+}
+
+func TestLoaderEarlyRefresh(t *testing.T) {
+	ctx := context.Background()
+	helper := NewMemoryCacheHelper(nil)
+	l := NewLoader(helper, LoaderOptions{EarlyRefreshBeta: 1})
+	// an entry past its logical expiry is still served while it is refreshed
+	expiry := time.Now().Add(-time.Second).UnixNano() / int64(time.Millisecond)
+	helper.Set(ctx, "key", loadedEntry{Value: json.RawMessage(`"old"`), Delta: 10, Expiry: expiry}, time.Minute)
+
+	refreshed := make(chan struct{})
+	var value string
+	err := l.GetOrLoad(ctx, "key", time.Minute, &value, func(ctx context.Context) (interface{}, error) {
+		defer close(refreshed)
+		return "new", nil
+	})
+	if err != nil || value != "old" {
+		t.Errorf("GetOrLoad() = %q, %v, want the cached value", value, err)
+	}
+	select {
+	case <-refreshed:
+	case <-time.After(time.Second):
+		t.Fatal("entry was not refreshed")
+	}
+	time.Sleep(10 * time.Millisecond)
+	if err := l.GetOrLoad(ctx, "key", time.Minute, &value, nil); err != nil || value != "new" {
+		t.Errorf("GetOrLoad() after refresh = %q, %v", value, err)
+	}
+}
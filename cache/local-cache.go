@@ -0,0 +1,194 @@
+package cache
+
+import (
+	"container/heap"
+	"container/list"
+	"sync"
+	"time"
+)
+
+// EvictionPolicy selects the entry evicted when a local cache is full
+type EvictionPolicy int
+
+const (
+	// EvictLRU evicts the least recently used entry
+	EvictLRU EvictionPolicy = iota
+	// EvictLFU evicts the least frequently used entry, the oldest one on a tie
+	EvictLFU
+)
+
+type localEntry struct {
+	key       string
+	data      []byte
+	expiresAt time.Time
+
+	// element is the position in the recency list (LRU)
+	element *list.Element
+	// freq, seq and index are the usage count, last access order and heap position (LFU)
+	freq  int64
+	seq   uint64
+	index int
+}
+
+func (e *localEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && !now.Before(e.expiresAt)
+}
+
+// localCache is a bounded in-process cache of encoded values with per-entry TTL
+type localCache struct {
+	mu       sync.Mutex
+	capacity int
+	policy   EvictionPolicy
+	entries  map[string]*localEntry
+	recency  *list.List
+	usage    lfuHeap
+	seq      uint64
+	now      func() time.Time
+}
+
+func newLocalCache(capacity int, policy EvictionPolicy) *localCache {
+	return &localCache{
+		capacity: capacity,
+		policy:   policy,
+		entries:  make(map[string]*localEntry, capacity),
+		recency:  list.New(),
+		now:      time.Now,
+	}
+}
+
+func (c *localCache) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if entry.expired(c.now()) {
+		c.remove(entry)
+		return nil, false
+	}
+	c.touch(entry)
+	return entry.data, true
+}
+
+// set stores data for ttl, a non-positive ttl keeps it until evicted
+func (c *localCache) set(key string, data []byte, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var expiresAt time.Time
+	if ttl > 0 {
+		expiresAt = c.now().Add(ttl)
+	}
+	if entry, ok := c.entries[key]; ok {
+		entry.data = data
+		entry.expiresAt = expiresAt
+		c.touch(entry)
+		return
+	}
+	for len(c.entries) >= c.capacity && len(c.entries) > 0 {
+		c.evict()
+	}
+
+	entry := &localEntry{key: key, data: data, expiresAt: expiresAt}
+	c.entries[key] = entry
+	switch c.policy {
+	case EvictLFU:
+		c.seq++
+		entry.seq = c.seq
+		heap.Push(&c.usage, entry)
+	default:
+		entry.element = c.recency.PushFront(entry)
+	}
+}
+
+func (c *localCache) del(keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, key := range keys {
+		if entry, ok := c.entries[key]; ok {
+			c.remove(entry)
+		}
+	}
+}
+
+func (c *localCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[string]*localEntry, c.capacity)
+	c.recency.Init()
+	c.usage = nil
+}
+
+func (c *localCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+func (c *localCache) touch(entry *localEntry) {
+	switch c.policy {
+	case EvictLFU:
+		c.seq++
+		entry.freq++
+		entry.seq = c.seq
+		heap.Fix(&c.usage, entry.index)
+	default:
+		c.recency.MoveToFront(entry.element)
+	}
+}
+
+func (c *localCache) evict() {
+	switch c.policy {
+	case EvictLFU:
+		c.remove(c.usage[0])
+	default:
+		c.remove(c.recency.Back().Value.(*localEntry))
+	}
+}
+
+func (c *localCache) remove(entry *localEntry) {
+	delete(c.entries, entry.key)
+	switch c.policy {
+	case EvictLFU:
+		heap.Remove(&c.usage, entry.index)
+	default:
+		c.recency.Remove(entry.element)
+	}
+}
+
+// lfuHeap orders entries by usage count then by last access
+type lfuHeap []*localEntry
+
+func (h lfuHeap) Len() int { return len(h) }
+
+func (h lfuHeap) Less(i, j int) bool {
+	if h[i].freq != h[j].freq {
+		return h[i].freq < h[j].freq
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h lfuHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *lfuHeap) Push(x interface{}) {
+	entry := x.(*localEntry)
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *lfuHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	entry := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return entry
+}
@@ -0,0 +1,59 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLocalCacheLRU(t *testing.T) {
+	c := newLocalCache(2, EvictLRU)
+	c.set("a", []byte("1"), 0)
+	c.set("b", []byte("2"), 0)
+	c.get("a")
+	c.set("c", []byte("3"), 0)
+
+	if _, ok := c.get("b"); ok {
+		t.Errorf("b should be evicted as least recently used")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := c.get(key); !ok {
+			t.Errorf("%s should be kept", key)
+		}
+	}
+}
+
+func TestLocalCacheLFU(t *testing.T) {
+	c := newLocalCache(2, EvictLFU)
+	c.set("a", []byte("1"), 0)
+	c.set("b", []byte("2"), 0)
+	c.get("a")
+	c.get("a")
+	c.get("b")
+	c.set("c", []byte("3"), 0)
+
+	if _, ok := c.get("b"); ok {
+		t.Errorf("b should be evicted as least frequently used")
+	}
+	c.del("a")
+	if c.len() != 1 {
+		t.Errorf("len() = %d, want 1", c.len())
+	}
+}
+
+func TestLocalCacheTTL(t *testing.T) {
+	now := time.Unix(0, 0)
+	c := newLocalCache(10, EvictLRU)
+	c.now = func() time.Time { return now }
+	c.set("a", []byte("1"), time.Second)
+
+	if data, ok := c.get("a"); !ok || string(data) != "1" {
+		t.Fatalf("get() = %q, %v, want 1, true", data, ok)
+	}
+	now = now.Add(time.Second)
+	if _, ok := c.get("a"); ok {
+		t.Errorf("a should be expired")
+	}
+	if c.len() != 0 {
+		t.Errorf("expired entry should be removed")
+	}
+}
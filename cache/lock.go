@@ -0,0 +1,284 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/binpossible49/go-libs/opentracing/jaeger"
+	"github.com/go-redis/redis/v7"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+const (
+	defaultLockKeyPrefix = "lock:"
+	// lockClockDriftFactor follows the Redlock algorithm, validity is reduced by 1% of the TTL plus 2ms
+	lockClockDriftFactor = 0.01
+	lockClockDriftMin    = 2 * time.Millisecond
+	// lockAbandonTimeout bounds releasing the nodes of a failed acquisition, which happens
+	// even when the caller gave up
+	lockAbandonTimeout = 5 * time.Second
+)
+
+var (
+	// ErrLockNotAcquired is returned when the lock is held by someone else
+	ErrLockNotAcquired = errors.New("cache: lock not acquired")
+	// ErrLockNotHeld is returned when refreshing or releasing a lock that expired or was taken
+	// over, errors reaching the nodes are returned instead when they may have cost the quorum
+	ErrLockNotHeld = errors.New("cache: lock not held")
+)
+
+var (
+	// acquireLockScript sets the lock and returns the next fencing token, 0 when already locked
+	acquireLockScript = newBuiltinScript("lock.acquire", `
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return redis.call("INCR", KEYS[2])
+end
+return 0`)
+	// raiseFenceScript makes the fencing counter at least ARGV[1]
+	raiseFenceScript = newBuiltinScript("lock.raiseFence", `
+local current = tonumber(redis.call("GET", KEYS[1]) or "0")
+if current < tonumber(ARGV[1]) then
+	redis.call("SET", KEYS[1], ARGV[1])
+end
+return 1`)
+	refreshLockScript = newBuiltinScript("lock.refresh", `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+	releaseLockScript = newBuiltinScript("lock.release", `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+)
+
+// LockHelper is helper of distributed locks
+type LockHelper interface {
+	Acquire(ctx context.Context, name string, ttl time.Duration) (Lock, error)
+}
+
+// Lock is a distributed lock held by the caller
+type Lock interface {
+	Name() string
+	// FencingToken increases with every acquisition of the lock, storages guarded by the
+	// lock should reject writes carrying a token lower than one already seen
+	FencingToken() int64
+	Refresh(ctx context.Context, ttl time.Duration) error
+	Release(ctx context.Context) error
+}
+
+// LockOptions represents options of LockHelper
+type LockOptions struct {
+	// KeyPrefix is prepended to lock names
+	KeyPrefix string
+	// RetryInterval makes Acquire retry until ctx is done, 0 tries only once. Acquire
+	// returns the error of ctx when it is done before the lock was acquired.
+	RetryInterval time.Duration
+}
+
+type lockHelper struct {
+	clients []redis.UniversalClient
+	quorum  int
+	opts    LockOptions
+}
+
+// NewLockHelper creates an instance locking on the redis behind helper
+func NewLockHelper(helper CacheHelper, opts LockOptions) (LockHelper, error) {
+	return NewRedlockHelper([]CacheHelper{helper}, opts)
+}
+
+// NewRedlockHelper creates an instance implementing Redlock over independent redis nodes,
+// a lock is held when a majority of them granted it
+func NewRedlockHelper(helpers []CacheHelper, opts LockOptions) (LockHelper, error) {
+	if len(helpers) == 0 {
+		return nil, errors.New("cache: at least one helper is required")
+	}
+	clients := make([]redis.UniversalClient, 0, len(helpers))
+	for _, helper := range helpers {
+		client, err := redisClientOf(helper)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+	if opts.KeyPrefix == "" {
+		opts.KeyPrefix = defaultLockKeyPrefix
+	}
+	return &lockHelper{
+		clients: clients,
+		quorum:  len(clients)/2 + 1,
+		opts:    opts,
+	}, nil
+}
+
+// lockKeys returns the lock and fencing counter keys, hash tagged to share a cluster slot
+func (h *lockHelper) lockKeys(name string) (string, string) {
+	key := h.opts.KeyPrefix + "{" + name + "}"
+	return key, key + ":fence"
+}
+
+func (h *lockHelper) Acquire(ctx context.Context, name string, ttl time.Duration) (lock Lock, err error) {
+	span := jaeger.Start(ctx, ">helper.lockHelper/Acquire", ext.SpanKindRPCClient, opentracing.Tag{Key: "lock.name", Value: name})
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	var retry *time.Timer
+	for {
+		lock, err = h.tryAcquire(ctx, name, ttl)
+		if err != nil && contextErr(ctx) != nil {
+			return nil, contextErr(ctx)
+		}
+		if err != ErrLockNotAcquired || h.opts.RetryInterval <= 0 {
+			return lock, err
+		}
+		if retry == nil {
+			retry = time.NewTimer(h.opts.RetryInterval)
+			defer retry.Stop()
+		} else {
+			retry.Reset(h.opts.RetryInterval)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-retry.C:
+		}
+	}
+}
+
+func (h *lockHelper) tryAcquire(ctx context.Context, name string, ttl time.Duration) (Lock, error) {
+	value, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	key, fenceKey := h.lockKeys(name)
+	lock := &redisLock{helper: h, name: name, key: key, value: value}
+
+	start := time.Now()
+	var lastErr error
+	var acquired []redis.UniversalClient
+	for _, client := range h.clients {
+		token, err := acquireLockScript.Run(withContext(ctx, client), []string{key, fenceKey}, value, int64(ttl/time.Millisecond)).Int64()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if token == 0 {
+			continue
+		}
+		acquired = append(acquired, client)
+		if token > lock.token {
+			lock.token = token
+		}
+	}
+
+	drift := time.Duration(float64(ttl)*lockClockDriftFactor) + lockClockDriftMin
+	if len(acquired) < h.quorum || time.Since(start)+drift >= ttl {
+		lock.abandon(ctx)
+		if lastErr != nil {
+			return nil, lastErr
+		}
+		return nil, ErrLockNotAcquired
+	}
+
+	// every node counts separately, raising them to the chosen token guarantees that
+	// the next majority sees it and hands out a greater one
+	if len(h.clients) > 1 {
+		raised := 0
+		for _, client := range acquired {
+			if err := raiseFenceScript.Run(withContext(ctx, client), []string{fenceKey}, lock.token).Err(); err == nil {
+				raised++
+			}
+		}
+		if raised < h.quorum {
+			lock.abandon(ctx)
+			return nil, ErrLockNotAcquired
+		}
+	}
+	return lock, nil
+}
+
+type redisLock struct {
+	helper *lockHelper
+	name   string
+	key    string
+	value  string
+	token  int64
+}
+
+func (l *redisLock) Name() string {
+	return l.name
+}
+
+func (l *redisLock) FencingToken() int64 {
+	return l.token
+}
+
+func (l *redisLock) Refresh(ctx context.Context, ttl time.Duration) (err error) {
+	span := jaeger.Start(ctx, ">helper.lockHelper/Refresh", ext.SpanKindRPCClient, opentracing.Tag{Key: "lock.name", Value: l.name})
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	refreshed, failed := 0, 0
+	var lastErr error
+	for _, client := range l.helper.clients {
+		ok, err := refreshLockScript.Run(withContext(ctx, client), []string{l.key}, l.value, int64(ttl/time.Millisecond)).Int64()
+		switch {
+		case err != nil:
+			failed++
+			lastErr = err
+		case ok == 1:
+			refreshed++
+		}
+	}
+	return l.quorumErr(refreshed, failed, lastErr)
+}
+
+func (l *redisLock) Release(ctx context.Context) (err error) {
+	span := jaeger.Start(ctx, ">helper.lockHelper/Release", ext.SpanKindRPCClient, opentracing.Tag{Key: "lock.name", Value: l.name})
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	return l.quorumErr(l.releaseOn(ctx))
+}
+
+// quorumErr tells whether done nodes make a quorum. Otherwise the lock is not held, unless
+// the failed nodes could have made the quorum, then their last error is returned.
+func (l *redisLock) quorumErr(done, failed int, lastErr error) error {
+	switch {
+	case done >= l.helper.quorum:
+		return nil
+	case done+failed >= l.helper.quorum:
+		return lastErr
+	}
+	return ErrLockNotHeld
+}
+
+// abandon releases the nodes of a failed acquisition, on a context of its own since ctx may
+// be why it failed and the nodes would stay locked until the TTL
+func (l *redisLock) abandon(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(detach(ctx), lockAbandonTimeout)
+	defer cancel()
+	l.releaseOn(ctx)
+}
+
+// releaseOn deletes the lock where it is still ours, it returns on how many nodes it was
+// and on how many the release failed with the last error
+func (l *redisLock) releaseOn(ctx context.Context) (released, failed int, lastErr error) {
+	for _, client := range l.helper.clients {
+		ok, err := releaseLockScript.Run(withContext(ctx, client), []string{l.key}, l.value).Int64()
+		switch {
+		case err != nil:
+			failed++
+			lastErr = err
+		case ok == 1:
+			released++
+		}
+	}
+	return released, failed, lastErr
+}
@@ -0,0 +1,215 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestLockAcquireRelease(t *testing.T) {
+	ctx := context.Background()
+	server, helper := newTestRedisHelper(t)
+	locks, err := NewLockHelper(helper, LockOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lock, err := locks.Acquire(ctx, "job", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := locks.Acquire(ctx, "job", time.Second); err != ErrLockNotAcquired {
+		t.Errorf("Acquire() of a held lock = %v, want ErrLockNotAcquired", err)
+	}
+	if err := lock.Release(ctx); err != nil {
+		t.Errorf("Release() = %v", err)
+	}
+	if server.Exists("lock:{job}") {
+		t.Error("Release() should delete the lock")
+	}
+	if err := lock.Release(ctx); err != ErrLockNotHeld {
+		t.Errorf("second Release() = %v, want ErrLockNotHeld", err)
+	}
+}
+
+func TestLockReleaseByNonOwner(t *testing.T) {
+	ctx := context.Background()
+	server, helper := newTestRedisHelper(t)
+	locks, _ := NewLockHelper(helper, LockOptions{})
+
+	lock, err := locks.Acquire(ctx, "job", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the lock expired and was taken over by another owner
+	server.Set("lock:{job}", "someone else")
+	if err := lock.Release(ctx); err != ErrLockNotHeld {
+		t.Errorf("Release() by a non-owner = %v, want ErrLockNotHeld", err)
+	}
+	if value, _ := server.Get("lock:{job}"); value != "someone else" {
+		t.Errorf("lock of the new owner = %q, should be kept", value)
+	}
+}
+
+func TestLockRefresh(t *testing.T) {
+	ctx := context.Background()
+	server, helper := newTestRedisHelper(t)
+	locks, _ := NewLockHelper(helper, LockOptions{})
+
+	lock, err := locks.Acquire(ctx, "job", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lock.Refresh(ctx, time.Minute); err != nil {
+		t.Errorf("Refresh() = %v", err)
+	}
+	if ttl := server.TTL("lock:{job}"); ttl != time.Minute {
+		t.Errorf("TTL after Refresh() = %v, want 1m", ttl)
+	}
+
+	server.FastForward(2 * time.Minute)
+	if err := lock.Refresh(ctx, time.Minute); err != ErrLockNotHeld {
+		t.Errorf("Refresh() of an expired lock = %v, want ErrLockNotHeld", err)
+	}
+}
+
+func TestLockFencingToken(t *testing.T) {
+	ctx := context.Background()
+	_, helper := newTestRedisHelper(t)
+	locks, _ := NewLockHelper(helper, LockOptions{})
+
+	var last int64
+	for i := 0; i < 3; i++ {
+		lock, err := locks.Acquire(ctx, "job", time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lock.FencingToken() <= last {
+			t.Errorf("FencingToken() = %d after %d, want increasing tokens", lock.FencingToken(), last)
+		}
+		last = lock.FencingToken()
+		lock.Release(ctx)
+	}
+}
+
+func TestRedlockFencingToken(t *testing.T) {
+	ctx := context.Background()
+	servers := make([]CacheHelper, 3)
+	first, helper := newTestRedisHelper(t)
+	servers[0] = helper
+	for i := 1; i < len(servers); i++ {
+		_, servers[i] = newTestRedisHelper(t)
+	}
+	locks, err := NewRedlockHelper(servers, LockOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a node ahead of the others must not make the token go back once it is unreachable
+	first.Set("lock:{job}:fence", "10")
+	lock, err := locks.Acquire(ctx, "job", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lock.FencingToken() != 11 {
+		t.Errorf("FencingToken() = %d, want 11", lock.FencingToken())
+	}
+	lock.Release(ctx)
+
+	first.SetError("ERR unreachable")
+	lock, err = locks.Acquire(ctx, "job", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lock.FencingToken() <= 11 {
+		t.Errorf("FencingToken() without the first node = %d, want more than 11", lock.FencingToken())
+	}
+}
+
+func TestLockAcquireCanceled(t *testing.T) {
+	_, helper := newTestRedisHelper(t)
+	locks, _ := NewLockHelper(helper, LockOptions{RetryInterval: 5 * time.Millisecond})
+	if _, err := locks.Acquire(context.Background(), "job", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	if _, err := locks.Acquire(ctx, "job", time.Minute); err != context.DeadlineExceeded {
+		t.Errorf("Acquire() until the deadline = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestLockAbandonAfterCancel(t *testing.T) {
+	servers := make([]CacheHelper, 3)
+	nodes := make([]*miniredis.Miniredis, 3)
+	for i := range servers {
+		nodes[i], servers[i] = newTestRedisHelper(t)
+	}
+	locks, _ := NewRedlockHelper(servers, LockOptions{})
+
+	// a minority acquired, the node locked is released
+	nodes[1].SetError("ERR unreachable")
+	nodes[2].SetError("ERR unreachable")
+	if _, err := locks.Acquire(context.Background(), "job", time.Minute); err == nil || err == ErrLockNotAcquired {
+		t.Errorf("Acquire() without a quorum = %v, want the error of the nodes", err)
+	}
+	if nodes[0].Exists("lock:{job}") {
+		t.Error("node acquired without a quorum should be released")
+	}
+	nodes[1].SetError("")
+	nodes[2].SetError("")
+
+	// the release outlives the caller giving up
+	lock, err := locks.Acquire(context.Background(), "job", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	lock.(*redisLock).abandon(canceled)
+	for i, node := range nodes {
+		if node.Exists("lock:{job}") {
+			t.Errorf("node %d still locked after abandon() with a canceled ctx", i)
+		}
+	}
+}
+
+func TestLockTransportErrors(t *testing.T) {
+	ctx := context.Background()
+	servers := make([]CacheHelper, 3)
+	nodes := make([]*miniredis.Miniredis, 3)
+	for i := range servers {
+		nodes[i], servers[i] = newTestRedisHelper(t)
+	}
+	locks, _ := NewRedlockHelper(servers, LockOptions{})
+	lock, err := locks.Acquire(ctx, "job", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// one node down still makes a quorum
+	nodes[0].SetError("ERR unreachable")
+	if err := lock.Refresh(ctx, time.Minute); err != nil {
+		t.Errorf("Refresh() with one node down = %v", err)
+	}
+
+	// redis being down is not losing the lock
+	nodes[1].SetError("ERR unreachable")
+	if err := lock.Refresh(ctx, time.Minute); err == nil || err == ErrLockNotHeld {
+		t.Errorf("Refresh() with two nodes down = %v, want their error", err)
+	}
+	if err := lock.Release(ctx); err == nil || err == ErrLockNotHeld {
+		t.Errorf("Release() with two nodes down = %v, want their error", err)
+	}
+
+	// the lock is not held once the nodes answering without it rule out a quorum
+	nodes[1].SetError("")
+	nodes[1].Del("lock:{job}")
+	nodes[2].Del("lock:{job}")
+	if err := lock.Refresh(ctx, time.Minute); err != ErrLockNotHeld {
+		t.Errorf("Refresh() of a lock lost on two nodes with the third down = %v, want ErrLockNotHeld", err)
+	}
+}
@@ -0,0 +1,338 @@
+package cache
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+const defaultScanCount = 10
+
+// Clock tells the in-memory CacheHelper what time it is
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// ManualClock is a Clock that only moves when told to, so that expiry is deterministic
+type ManualClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewManualClock creates a clock stopped at now
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now}
+}
+
+// Now returns the current time of the clock
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by d
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Set moves the clock to now
+func (c *ManualClock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+}
+
+type memoryEntry struct {
+	data      []byte
+	expiresAt time.Time
+}
+
+func (e *memoryEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && !now.Before(e.expiresAt)
+}
+
+type memoryCacheHelper struct {
+	mu      sync.Mutex
+	clock   Clock
+	entries map[string]*memoryEntry
+}
+
+// NewMemoryCacheHelper creates an in-memory instance behaving like the redis one, values are
+// encoded the same way and misses match ErrNotFound. A nil clock is the wall clock.
+func NewMemoryCacheHelper(clock Clock) CacheHelper {
+	if clock == nil {
+		clock = systemClock{}
+	}
+	return &memoryCacheHelper{
+		clock:   clock,
+		entries: make(map[string]*memoryEntry),
+	}
+}
+
+// lookup returns the live entry of key, removing it once expired, callers hold mu
+func (h *memoryCacheHelper) lookup(key string) (*memoryEntry, bool) {
+	entry, ok := h.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if entry.expired(h.clock.Now()) {
+		delete(h.entries, key)
+		return nil, false
+	}
+	return entry, true
+}
+
+func (h *memoryCacheHelper) load(key string) ([]byte, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	entry, ok := h.lookup(key)
+	if !ok {
+		return nil, &notFoundError{}
+	}
+	return entry.data, nil
+}
+
+// store writes data, a non-positive expiration keeps the key forever like SET does, callers hold mu
+func (h *memoryCacheHelper) store(key string, data []byte, expiration time.Duration) {
+	entry := &memoryEntry{data: data}
+	if expiration > 0 {
+		entry.expiresAt = h.clock.Now().Add(expiration)
+	}
+	h.entries[key] = entry
+}
+
+func (h *memoryCacheHelper) Exists(ctx context.Context, key string) error {
+	_, err := h.load(key)
+	return err
+}
+
+func (h *memoryCacheHelper) Get(ctx context.Context, key string, value interface{}) error {
+	data, err := h.load(key)
+	if err != nil {
+		return err
+	}
+	return defaultCodec.decode(data, value)
+}
+
+func (h *memoryCacheHelper) GetInterface(ctx context.Context, key string, value interface{}) (interface{}, error) {
+	data, err := h.load(key)
+	if err != nil {
+		return nil, err
+	}
+	return decodeInterface(value, func(out interface{}) error {
+		return defaultCodec.decode(data, out)
+	})
+}
+
+func (h *memoryCacheHelper) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	data, err := defaultCodec.encode(value)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.store(key, data, expiration)
+	return nil
+}
+
+func (h *memoryCacheHelper) Del(ctx context.Context, key string) error {
+	return h.DelMulti(ctx, key)
+}
+
+// Expire sets the TTL of key, a non-positive expiration deletes it like EXPIRE does
+func (h *memoryCacheHelper) Expire(ctx context.Context, key string, expiration time.Duration) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	entry, ok := h.lookup(key)
+	if !ok {
+		return nil
+	}
+	if expiration <= 0 {
+		delete(h.entries, key)
+		return nil
+	}
+	entry.expiresAt = h.clock.Now().Add(expiration)
+	return nil
+}
+
+func (h *memoryCacheHelper) DelMulti(ctx context.Context, keys ...string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, key := range keys {
+		delete(h.entries, key)
+	}
+	return nil
+}
+
+// GetKeysByPattern follows SCAN: the cursor is a position in the sorted keys, limit keys are
+// examined per call and only the matching ones returned, a zero cursor ends the iteration.
+// Keys written during the iteration may be missed or returned twice.
+func (h *memoryCacheHelper) GetKeysByPattern(ctx context.Context, pattern string, cursor uint64, limit int64) ([]string, uint64, error) {
+	if limit <= 0 {
+		limit = defaultScanCount
+	}
+
+	h.mu.Lock()
+	now := h.clock.Now()
+	all := make([]string, 0, len(h.entries))
+	for key, entry := range h.entries {
+		if entry.expired(now) {
+			delete(h.entries, key)
+			continue
+		}
+		all = append(all, key)
+	}
+	h.mu.Unlock()
+	sort.Strings(all)
+
+	if cursor >= uint64(len(all)) {
+		return []string{}, 0, nil
+	}
+	end := cursor + uint64(limit)
+	if end > uint64(len(all)) {
+		end = uint64(len(all))
+	}
+	keys := []string{}
+	for _, key := range all[cursor:end] {
+		if pattern == "" || matchPattern(pattern, key) {
+			keys = append(keys, key)
+		}
+	}
+	if end == uint64(len(all)) {
+		end = 0
+	}
+	return keys, end, nil
+}
+
+func (h *memoryCacheHelper) MGet(ctx context.Context, keys []string, values interface{}) error {
+	raws := make([][]byte, len(keys))
+	h.mu.Lock()
+	for i, key := range keys {
+		if entry, ok := h.lookup(key); ok {
+			raws[i] = entry.data
+		}
+	}
+	h.mu.Unlock()
+	return fillMGetResult(keys, raws, values, defaultCodec.decode)
+}
+
+func (h *memoryCacheHelper) MSet(ctx context.Context, items ...Item) error {
+	encoded := make([][]byte, len(items))
+	for i, item := range items {
+		data, err := defaultCodec.encode(item.Value)
+		if err != nil {
+			return err
+		}
+		encoded[i] = data
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, item := range items {
+		h.store(item.Key, encoded[i], item.Expiration)
+	}
+	return nil
+}
+
+// matchPattern reports whether key matches the glob pattern the way redis does:
+// * and ? match any run and any single byte, [abc], [^abc] and [a-z] match classes,
+// and a backslash escapes the next byte
+func matchPattern(pattern, key string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(key); i++ {
+				if matchPattern(pattern[1:], key[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(key) == 0 {
+				return false
+			}
+			key = key[1:]
+			pattern = pattern[1:]
+		case '[':
+			if len(key) == 0 {
+				return false
+			}
+			matched, rest := matchClass(pattern[1:], key[0])
+			if !matched {
+				return false
+			}
+			key = key[1:]
+			pattern = rest
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(key) == 0 || pattern[0] != key[0] {
+				return false
+			}
+			key = key[1:]
+			pattern = pattern[1:]
+		}
+	}
+	return len(key) == 0
+}
+
+// matchClass matches c against the class starting after '[' and returns the pattern
+// left after the closing ']', an unterminated class runs to the end of the pattern
+func matchClass(pattern string, c byte) (bool, string) {
+	negate := false
+	if len(pattern) > 0 && pattern[0] == '^' {
+		negate = true
+		pattern = pattern[1:]
+	}
+	matched := false
+	for len(pattern) > 0 && pattern[0] != ']' {
+		switch {
+		case pattern[0] == '\\' && len(pattern) > 1:
+			if pattern[1] == c {
+				matched = true
+			}
+			pattern = pattern[2:]
+		case len(pattern) > 2 && pattern[1] == '-' && pattern[2] != ']':
+			low, high := pattern[0], pattern[2]
+			if low > high {
+				low, high = high, low
+			}
+			if c >= low && c <= high {
+				matched = true
+			}
+			pattern = pattern[3:]
+		default:
+			if pattern[0] == c {
+				matched = true
+			}
+			pattern = pattern[1:]
+		}
+	}
+	if len(pattern) > 0 {
+		pattern = pattern[1:]
+	}
+	return matched != negate, pattern
+}
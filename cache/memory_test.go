@@ -0,0 +1,116 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestMemoryCacheHelperExpiry(t *testing.T) {
+	ctx := context.Background()
+	clock := NewManualClock(time.Unix(0, 0))
+	h := NewMemoryCacheHelper(clock)
+
+	if err := h.Set(ctx, "a", "1", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Set(ctx, "b", "2", 0); err != nil {
+		t.Fatal(err)
+	}
+	var value string
+	if err := h.Get(ctx, "a", &value); err != nil || value != "1" {
+		t.Fatalf("Get() = %q, %v, want 1, nil", value, err)
+	}
+
+	clock.Advance(time.Second)
+	if err := h.Exists(ctx, "a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Exists(a) = %v, want ErrNotFound", err)
+	}
+	if err := h.Get(ctx, "a", &value); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(a) = %v, want ErrNotFound", err)
+	}
+	if err := h.Exists(ctx, "b"); err != nil {
+		t.Errorf("Exists(b) = %v, want nil", err)
+	}
+
+	if err := h.Expire(ctx, "b", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Minute)
+	if err := h.Exists(ctx, "b"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Exists(b) after Expire = %v, want ErrNotFound", err)
+	}
+}
+
+func TestMemoryCacheHelperDelMulti(t *testing.T) {
+	ctx := context.Background()
+	h := NewMemoryCacheHelper(nil)
+	MSet(ctx, h, Item{Key: "a", Value: 1}, Item{Key: "b", Value: 2}, Item{Key: "c", Value: 3})
+
+	if err := h.DelMulti(ctx, "a", "b", "missing"); err != nil {
+		t.Fatal(err)
+	}
+	var values []*int
+	if err := MGet(ctx, h, []string{"a", "b", "c"}, &values); err != nil {
+		t.Fatal(err)
+	}
+	if values[0] != nil || values[1] != nil || values[2] == nil || *values[2] != 3 {
+		t.Errorf("MGet() after DelMulti = %v", values)
+	}
+}
+
+func TestMemoryCacheHelperGetKeysByPattern(t *testing.T) {
+	ctx := context.Background()
+	h := NewMemoryCacheHelper(nil)
+	for _, key := range []string{"user:1", "user:2", "user:10", "order:1", "hello", "hallo", "hxllo"} {
+		h.Set(ctx, key, true, 0)
+	}
+
+	var keys []string
+	var cursor uint64
+	for {
+		page, next, err := h.GetKeysByPattern(ctx, "user:*", cursor, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, page...)
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	sort.Strings(keys)
+	if want := []string{"user:1", "user:10", "user:2"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("GetKeysByPattern() = %v, want %v", keys, want)
+	}
+}
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pattern, key string
+		want         bool
+	}{
+		{"*", "anything", true},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h*llo", "heeeello", true},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h[a-b]llo", "hcllo", false},
+		{`h\*llo`, "h*llo", true},
+		{`h\*llo`, "hello", false},
+		{"user:*:name", "user:1:name", true},
+		{"user:*:name", "user:1:email", false},
+	}
+	for _, test := range tests {
+		if got := matchPattern(test.pattern, test.key); got != test.want {
+			t.Errorf("matchPattern(%q, %q) = %v, want %v", test.pattern, test.key, got, test.want)
+		}
+	}
+}
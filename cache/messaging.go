@@ -0,0 +1,439 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/binpossible49/go-libs/opentracing/jaeger"
+	"github.com/go-redis/redis/v7"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"go.uber.org/zap"
+)
+
+const (
+	streamPayloadField          = "payload"
+	defaultStreamBlock          = 5 * time.Second
+	defaultStreamCount          = 10
+	defaultStreamClaimMinIdle   = time.Minute
+	defaultStreamClaimInterval  = 30 * time.Second
+	subscriptionRetryInterval   = time.Second
+	busyGroupErrorPrefix        = "BUSYGROUP"
+	streamConsumerRetryInterval = time.Second
+)
+
+// MessagingHelper is helper of redis pub/sub and streams, the trace context travels with
+// every message like the kafka headers do
+type MessagingHelper interface {
+	Publish(ctx context.Context, channel string, value interface{}) error
+	// Subscribe calls handler for every message of channels until ctx is done,
+	// the subscription is restored after connection failures
+	Subscribe(ctx context.Context, handler MessageHandler, channels ...string) error
+
+	// XAdd appends value to stream, trimming it to about maxLen entries when positive
+	XAdd(ctx context.Context, stream string, value interface{}, maxLen int64) (string, error)
+	// CreateGroup creates a consumer group starting at start ("$" for new entries, "0" for all),
+	// the stream is created if needed and an existing group is not an error
+	CreateGroup(ctx context.Context, stream, group, start string) error
+	// Consume reads stream as a member of a consumer group until ctx is done, entries are
+	// acknowledged when handler succeeds and entries left pending are claimed once stale
+	Consume(ctx context.Context, opts StreamConsumerOptions, handler StreamHandler) error
+	Ack(ctx context.Context, stream, group string, ids ...string) error
+	// ClaimStale takes over up to count entries pending for at least minIdle, the oldest first
+	ClaimStale(ctx context.Context, stream, group, consumer string, minIdle time.Duration, count int64) ([]*StreamMessage, error)
+}
+
+// MessageHandler handles a pub/sub message, ctx carries the span of the publisher
+type MessageHandler func(ctx context.Context, message *Message) error
+
+// StreamHandler handles a stream entry, ctx carries the span of the producer
+type StreamHandler func(ctx context.Context, message *StreamMessage) error
+
+// Message is a pub/sub message
+type Message struct {
+	Channel string
+	Payload []byte
+	codec   *codec
+}
+
+// Decode decodes the payload into value
+func (m *Message) Decode(value interface{}) error {
+	return m.codec.decode(m.Payload, value)
+}
+
+// StreamMessage is a stream entry
+type StreamMessage struct {
+	Stream  string
+	ID      string
+	Payload []byte
+	fields  map[string]interface{}
+	codec   *codec
+}
+
+// Decode decodes the payload into value
+func (m *StreamMessage) Decode(value interface{}) error {
+	return m.codec.decode(m.Payload, value)
+}
+
+// StreamConsumerOptions represents options of a stream consumer
+type StreamConsumerOptions struct {
+	Stream   string
+	Group    string
+	Consumer string
+	// Count is the maximum number of entries read at once
+	Count int64
+	// Block is how long a read waits for new entries
+	Block time.Duration
+	// ClaimMinIdle is how long an entry stays pending before it is claimed
+	ClaimMinIdle time.Duration
+	// ClaimInterval is how often pending entries are checked
+	ClaimInterval time.Duration
+}
+
+// pubsubEnvelope carries the trace context along with a pub/sub payload. JSON payloads are
+// embedded as they are so that other subscribers can read them, the others are in Binary.
+type pubsubEnvelope struct {
+	Headers map[string]string `json:"h"`
+	Payload json.RawMessage   `json:"p,omitempty"`
+	Binary  []byte            `json:"b,omitempty"`
+}
+
+// payload returns the encoded value carried by the envelope
+func (e pubsubEnvelope) payload() []byte {
+	if e.Binary != nil {
+		return e.Binary
+	}
+	return e.Payload
+}
+
+type messagingHelper struct {
+	client redis.UniversalClient
+	codec  *codec
+}
+
+// NewMessagingHelper creates an instance on the redis behind helper.
+// Only helpers created by NewCacheHelper are supported, decorators would be bypassed.
+func NewMessagingHelper(helper CacheHelper) (MessagingHelper, error) {
+	h, ok := helper.(redisClientHelper)
+	if !ok {
+		return nil, ErrUnsupportedHelper
+	}
+	return &messagingHelper{
+		client: h.redisClient(),
+		codec:  h.valueCodec(),
+	}, nil
+}
+
+func (h *messagingHelper) Publish(ctx context.Context, channel string, value interface{}) (err error) {
+	span := jaeger.Start(ctx, ">helper.messagingHelper/Publish", ext.SpanKindProducer, opentracing.Tag{Key: "message_bus.destination", Value: channel})
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	payload, err := h.codec.encode(value)
+	if err != nil {
+		return err
+	}
+	envelope := pubsubEnvelope{Headers: map[string]string{}}
+	if json.Valid(payload) {
+		envelope.Payload = payload
+	} else {
+		envelope.Binary = payload
+	}
+	if err := span.Tracer().Inject(span.Context(), opentracing.TextMap, opentracing.TextMapCarrier(envelope.Headers)); err != nil {
+		zap.S().Debugw("Failed to inject span into message", zap.Error(err))
+	}
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+	return withContext(ctx, h.client).Publish(channel, data).Err()
+}
+
+func (h *messagingHelper) Subscribe(ctx context.Context, handler MessageHandler, channels ...string) error {
+	pubsub := h.client.Subscribe(channels...)
+	if _, err := pubsub.Receive(); err != nil {
+		pubsub.Close()
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		pubsub.Close()
+	}()
+
+	go func() {
+		for {
+			msg, err := pubsub.Receive()
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				zap.S().Warnw("Redis subscription failed", "channels", channels, zap.Error(err))
+				time.Sleep(subscriptionRetryInterval)
+				continue
+			}
+			if message, ok := msg.(*redis.Message); ok {
+				h.handleMessage(ctx, message, handler)
+			}
+		}
+	}()
+	return nil
+}
+
+// openEnvelope returns the envelope of a message published by Publish. Messages of other
+// publishers are wrapped as they are, JSON ones included: an object is only taken for
+// an envelope when it has the headers field, at most one of the payload fields and
+// nothing else.
+func openEnvelope(data string) pubsubEnvelope {
+	raw := pubsubEnvelope{Payload: []byte(data)}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(data), &fields); err != nil {
+		return raw
+	}
+	_, hasPayload := fields["p"]
+	_, hasBinary := fields["b"]
+	if _, ok := fields["h"]; !ok || hasPayload && hasBinary || len(fields) > 2 {
+		return raw
+	}
+	var envelope pubsubEnvelope
+	if err := json.Unmarshal([]byte(data), &envelope); err != nil {
+		return raw
+	}
+	return envelope
+}
+
+func (h *messagingHelper) handleMessage(ctx context.Context, msg *redis.Message, handler MessageHandler) {
+	envelope := openEnvelope(msg.Payload)
+
+	var span opentracing.Span
+	spanCtx, err := opentracing.GlobalTracer().Extract(opentracing.TextMap, opentracing.TextMapCarrier(envelope.Headers))
+	if err == nil {
+		span = jaeger.Continue(spanCtx, ">consumer.messagingHelper/"+msg.Channel, ext.SpanKindConsumer)
+	} else {
+		span = jaeger.Start(ctx, ">consumer.messagingHelper/"+msg.Channel, ext.SpanKindConsumer)
+	}
+	err = handler(opentracing.ContextWithSpan(ctx, span), &Message{
+		Channel: msg.Channel,
+		Payload: envelope.payload(),
+		codec:   h.codec,
+	})
+	if err != nil {
+		zap.S().Errorw("Failed to handle redis message", "channel", msg.Channel, zap.Error(err))
+	}
+	jaeger.Finish(span, err)
+}
+
+func (h *messagingHelper) XAdd(ctx context.Context, stream string, value interface{}, maxLen int64) (id string, err error) {
+	span := jaeger.Start(ctx, ">helper.messagingHelper/XAdd", ext.SpanKindProducer, opentracing.Tag{Key: "message_bus.destination", Value: stream})
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	payload, err := h.codec.encode(value)
+	if err != nil {
+		return "", err
+	}
+	fields := map[string]interface{}{streamPayloadField: payload}
+	if err := jaeger.InjectRedisStreamFields(span, fields); err != nil {
+		zap.S().Debugw("Failed to inject span into stream entry", zap.Error(err))
+	}
+	return withContext(ctx, h.client).XAdd(&redis.XAddArgs{
+		Stream:       stream,
+		MaxLenApprox: maxLen,
+		Values:       fields,
+	}).Result()
+}
+
+func (h *messagingHelper) CreateGroup(ctx context.Context, stream, group, start string) (err error) {
+	span := jaeger.Start(ctx, ">helper.messagingHelper/CreateGroup", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	err = withContext(ctx, h.client).XGroupCreateMkStream(stream, group, start).Err()
+	if err != nil && strings.Contains(err.Error(), busyGroupErrorPrefix) {
+		return nil
+	}
+	return err
+}
+
+func (h *messagingHelper) Consume(ctx context.Context, opts StreamConsumerOptions, handler StreamHandler) error {
+	if opts.Stream == "" || opts.Group == "" || opts.Consumer == "" {
+		return errors.New("cache: stream, group and consumer are required")
+	}
+	if opts.Count <= 0 {
+		opts.Count = defaultStreamCount
+	}
+	if opts.Block <= 0 {
+		opts.Block = defaultStreamBlock
+	}
+	if opts.ClaimMinIdle <= 0 {
+		opts.ClaimMinIdle = defaultStreamClaimMinIdle
+	}
+	if opts.ClaimInterval <= 0 {
+		opts.ClaimInterval = defaultStreamClaimInterval
+	}
+	if err := h.CreateGroup(ctx, opts.Stream, opts.Group, "$"); err != nil {
+		return err
+	}
+
+	var lastClaim time.Time
+	for ctx.Err() == nil {
+		if time.Since(lastClaim) >= opts.ClaimInterval {
+			lastClaim = time.Now()
+			messages, err := h.ClaimStale(ctx, opts.Stream, opts.Group, opts.Consumer, opts.ClaimMinIdle, opts.Count)
+			if err != nil {
+				zap.S().Warnw("Failed to claim stale stream entries", "stream", opts.Stream, zap.Error(err))
+			}
+			h.handleStreamMessages(ctx, opts, messages, handler)
+		}
+
+		streams, err := withContext(ctx, h.client).XReadGroup(&redis.XReadGroupArgs{
+			Group:    opts.Group,
+			Consumer: opts.Consumer,
+			Streams:  []string{opts.Stream, ">"},
+			Count:    opts.Count,
+			Block:    opts.Block,
+		}).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			zap.S().Warnw("Failed to read stream", "stream", opts.Stream, zap.Error(err))
+			time.Sleep(streamConsumerRetryInterval)
+			continue
+		}
+		for _, stream := range streams {
+			h.handleStreamMessages(ctx, opts, h.streamMessages(stream.Stream, stream.Messages), handler)
+		}
+	}
+	return ctx.Err()
+}
+
+func (h *messagingHelper) handleStreamMessages(ctx context.Context, opts StreamConsumerOptions, messages []*StreamMessage, handler StreamHandler) {
+	for _, message := range messages {
+		var span opentracing.Span
+		spanCtx, err := jaeger.ExtractRedisStreamFields(message.fields)
+		if err == nil {
+			span = jaeger.Continue(spanCtx, ">consumer.messagingHelper/"+message.Stream, ext.SpanKindConsumer)
+		} else {
+			span = jaeger.Start(ctx, ">consumer.messagingHelper/"+message.Stream, ext.SpanKindConsumer)
+		}
+		span.SetTag("message_bus.message_id", message.ID)
+
+		handlerCtx := opentracing.ContextWithSpan(ctx, span)
+		err = handler(handlerCtx, message)
+		if err != nil {
+			// left pending, it is claimed again once stale
+			zap.S().Errorw("Failed to handle stream entry", "stream", message.Stream, "id", message.ID, zap.Error(err))
+		} else {
+			err = h.Ack(handlerCtx, message.Stream, opts.Group, message.ID)
+		}
+		jaeger.Finish(span, err)
+	}
+}
+
+func (h *messagingHelper) Ack(ctx context.Context, stream, group string, ids ...string) (err error) {
+	span := jaeger.Start(ctx, ">helper.messagingHelper/Ack", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	return withContext(ctx, h.client).XAck(stream, group, ids...).Err()
+}
+
+func (h *messagingHelper) ClaimStale(ctx context.Context, stream, group, consumer string, minIdle time.Duration, count int64) (messages []*StreamMessage, err error) {
+	span := jaeger.Start(ctx, ">helper.messagingHelper/ClaimStale", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	// XPENDING has no idle filter before redis 6.2, the pending entries are paged
+	// through until count of them are stale
+	var ids []string
+	start := "-"
+	for int64(len(ids)) < count {
+		pending, err := withContext(ctx, h.client).XPendingExt(&redis.XPendingExtArgs{
+			Stream: stream,
+			Group:  group,
+			Start:  start,
+			End:    "+",
+			Count:  count,
+		}).Result()
+		if err != nil && err != redis.Nil {
+			return nil, err
+		}
+		for _, entry := range pending {
+			if entry.Idle >= minIdle && int64(len(ids)) < count {
+				ids = append(ids, entry.ID)
+			}
+		}
+		if int64(len(pending)) < count {
+			break
+		}
+		if start, err = nextStreamID(pending[len(pending)-1].ID); err != nil {
+			return nil, err
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	claimed, err := withContext(ctx, h.client).XClaim(&redis.XClaimArgs{
+		Stream:   stream,
+		Group:    group,
+		Consumer: consumer,
+		MinIdle:  minIdle,
+		Messages: ids,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	return h.streamMessages(stream, claimed), nil
+}
+
+// nextStreamID returns the smallest stream ID greater than id
+func nextStreamID(id string) (string, error) {
+	parts := strings.SplitN(id, "-", 2)
+	if len(parts) != 2 {
+		return "", fmt.Errorf("cache: invalid stream ID %q", id)
+	}
+	ms, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("cache: invalid stream ID %q", id)
+	}
+	seq, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("cache: invalid stream ID %q", id)
+	}
+	if seq == math.MaxUint64 {
+		return strconv.FormatUint(ms+1, 10) + "-0", nil
+	}
+	return parts[0] + "-" + strconv.FormatUint(seq+1, 10), nil
+}
+
+func (h *messagingHelper) streamMessages(stream string, entries []redis.XMessage) []*StreamMessage {
+	messages := make([]*StreamMessage, 0, len(entries))
+	for _, entry := range entries {
+		message := &StreamMessage{
+			Stream: stream,
+			ID:     entry.ID,
+			fields: entry.Values,
+			codec:  h.codec,
+		}
+		if payload, ok := entry.Values[streamPayloadField].(string); ok {
+			message.Payload = []byte(payload)
+		}
+		messages = append(messages, message)
+	}
+	return messages
+}
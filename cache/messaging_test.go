@@ -0,0 +1,188 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+func TestOpenEnvelope(t *testing.T) {
+	payload, _ := json.Marshal(map[string]int{"id": 1})
+	enveloped, _ := json.Marshal(pubsubEnvelope{Headers: map[string]string{"trace": "1"}, Payload: payload})
+	bare, _ := json.Marshal(pubsubEnvelope{Payload: payload})
+	binary, _ := json.Marshal(pubsubEnvelope{Headers: map[string]string{}, Binary: []byte{0x84, 1}})
+
+	tests := []struct {
+		name    string
+		data    string
+		payload string
+		headers map[string]string
+	}{
+		{"envelope", string(enveloped), string(payload), map[string]string{"trace": "1"}},
+		{"envelope without headers", string(bare), string(payload), nil},
+		{"binary envelope", string(binary), "\x84\x01", nil},
+		{"empty envelope", `{"h":{}}`, "", nil},
+		{"json object", `{"id":1}`, `{"id":1}`, nil},
+		{"object with a p field", `{"p":"x","q":1}`, `{"p":"x","q":1}`, nil},
+		{"object without headers", `{"p":42}`, `{"p":42}`, nil},
+		{"both payload fields", `{"h":{},"p":1,"b":"AQ=="}`, `{"h":{},"p":1,"b":"AQ=="}`, nil},
+		{"json array", `[1,2]`, `[1,2]`, nil},
+		{"text", "hello", "hello", nil},
+	}
+	for _, tt := range tests {
+		envelope := openEnvelope(tt.data)
+		if string(envelope.payload()) != tt.payload || len(envelope.Headers) != len(tt.headers) {
+			t.Errorf("%s: openEnvelope() = %q %v, want %q %v", tt.name, envelope.payload(), envelope.Headers, tt.payload, tt.headers)
+		}
+	}
+}
+
+func TestPublishEnvelope(t *testing.T) {
+	ctx := context.Background()
+	_, helper := newTestRedisHelper(t)
+	client, _ := redisClientOf(helper)
+	pubsub := client.Subscribe("events")
+	defer pubsub.Close()
+	if _, err := pubsub.Receive(); err != nil {
+		t.Fatal(err)
+	}
+
+	h, _ := NewMessagingHelper(helper)
+	if err := h.Publish(ctx, "events", map[string]int{"id": 1}); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := pubsub.ReceiveMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// subscribers outside this package read JSON payloads as they are
+	var envelope struct {
+		Payload map[string]int `json:"p"`
+	}
+	if err := json.Unmarshal([]byte(msg.Payload), &envelope); err != nil || envelope.Payload["id"] != 1 {
+		t.Errorf("published %s, want the payload as JSON", msg.Payload)
+	}
+
+	msgpack, _ := newCodec(MsgpackSerializer, CompressionNone, 0)
+	h = &messagingHelper{client: client, codec: msgpack}
+	if err := h.Publish(ctx, "events", map[string]int{"id": 2}); err != nil {
+		t.Fatal(err)
+	}
+	if msg, err = pubsub.ReceiveMessage(); err != nil {
+		t.Fatal(err)
+	}
+	var value map[string]int
+	if err := msgpack.decode(openEnvelope(msg.Payload).payload(), &value); err != nil || value["id"] != 2 {
+		t.Errorf("decoded %v, %v from %q, want id 2", value, err, msg.Payload)
+	}
+}
+
+func TestNextStreamID(t *testing.T) {
+	tests := map[string]string{
+		"1-0":                    "1-1",
+		"1526919030474-55":       "1526919030474-56",
+		"5-18446744073709551615": "6-0",
+	}
+	for id, want := range tests {
+		if got, err := nextStreamID(id); err != nil || got != want {
+			t.Errorf("nextStreamID(%q) = %q, %v, want %q", id, got, err, want)
+		}
+	}
+	for _, id := range []string{"1", "a-1", "1-b"} {
+		if _, err := nextStreamID(id); err == nil {
+			t.Errorf("nextStreamID(%q) = nil error", id)
+		}
+	}
+}
+
+func TestPublishSubscribe(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server, helper := newTestRedisHelper(t)
+	h, err := NewMessagingHelper(helper)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received := make(chan map[string]int, 2)
+	err = h.Subscribe(ctx, func(ctx context.Context, message *Message) error {
+		var value map[string]int
+		if err := message.Decode(&value); err != nil {
+			t.Errorf("Decode() = %v", err)
+		}
+		received <- value
+		return nil
+	}, "events")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.Publish(ctx, "events", map[string]int{"id": 1}); err != nil {
+		t.Fatal(err)
+	}
+	// a publisher outside this package sends bare JSON
+	server.Publish("events", `{"id":2}`)
+	for want := 1; want <= 2; want++ {
+		select {
+		case value := <-received:
+			if value["id"] != want {
+				t.Errorf("received %v, want id %d", value, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("message %d not received", want)
+		}
+	}
+}
+
+func TestClaimStalePages(t *testing.T) {
+	ctx := context.Background()
+	_, helper := newTestRedisHelper(t)
+	h, _ := NewMessagingHelper(helper)
+	client, _ := redisClientOf(helper)
+
+	if err := h.CreateGroup(ctx, "jobs", "workers", "$"); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.CreateGroup(ctx, "jobs", "workers", "$"); err != nil {
+		t.Errorf("CreateGroup() of an existing group = %v", err)
+	}
+	var ids []string
+	for i := 0; i < 10; i++ {
+		id, err := h.XAdd(ctx, "jobs", i, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	err := client.XReadGroup(&redis.XReadGroupArgs{Group: "workers", Consumer: "crashed", Streams: []string{"jobs", ">"}, Count: 10}).Err()
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	// the oldest entries are taken over by a live consumer, they are no longer stale
+	err = client.XClaim(&redis.XClaimArgs{Stream: "jobs", Group: "workers", Consumer: "live", Messages: ids[:5]}).Err()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages, err := h.ClaimStale(ctx, "jobs", "workers", "me", 50*time.Millisecond, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 3 {
+		t.Fatalf("ClaimStale() claimed %d entries, want 3 past the first page", len(messages))
+	}
+	for i, message := range messages {
+		var value int
+		if message.ID != ids[5+i] || message.Decode(&value) != nil || value != 5+i {
+			t.Errorf("claimed %s = %d, want %s", message.ID, value, ids[5+i])
+		}
+	}
+
+	if messages, err := h.ClaimStale(ctx, "jobs", "workers", "me", time.Hour, 3); err != nil || len(messages) != 0 {
+		t.Errorf("ClaimStale() without stale entries = %d, %v", len(messages), err)
+	}
+}
@@ -0,0 +1,213 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+const (
+	// ResultOK is the result of a command that succeeded
+	ResultOK = "ok"
+	// ResultError is the result of a command that failed, misses are not failures
+	ResultError = "error"
+	// ResultHit is counted for every key a read found
+	ResultHit = "hit"
+	// ResultMiss is counted for every key a read did not find
+	ResultMiss = "miss"
+)
+
+// Metrics receives measurements of the redis commands sent by the helpers
+type Metrics interface {
+	// ObserveCommand records a command, or a pipeline as "pipeline", with ResultOK or ResultError
+	ObserveCommand(command, result string, duration time.Duration)
+	// ObserveLookups records the keys found and not found by a read command
+	ObserveLookups(command string, hits, misses int)
+	// ObservePayloadSize records the size in bytes of the values written or read by a command
+	ObservePayloadSize(command string, size int)
+	// ObserveBreakerState records the state of the circuit breaker named name
+	ObserveBreakerState(name string, state BreakerState)
+}
+
+// PoolStats are the connection pool statistics of a helper
+type PoolStats struct {
+	// Hits, Misses and Timeouts count the times a free connection was found, was not
+	// found and could not be obtained in time
+	Hits     uint32
+	Misses   uint32
+	Timeouts uint32
+
+	TotalConns uint32
+	IdleConns  uint32
+	StaleConns uint32
+}
+
+// PoolStatsOf returns the connection pool statistics of the redis client behind helper
+func PoolStatsOf(helper CacheHelper) (*PoolStats, error) {
+	client, err := redisClientOf(helper)
+	if err != nil {
+		return nil, err
+	}
+	var stats *redis.PoolStats
+	switch c := client.(type) {
+	case *redis.Client:
+		stats = c.PoolStats()
+	case *redis.ClusterClient:
+		stats = c.PoolStats()
+	default:
+		return nil, ErrUnsupportedHelper
+	}
+	return &PoolStats{
+		Hits:       stats.Hits,
+		Misses:     stats.Misses,
+		Timeouts:   stats.Timeouts,
+		TotalConns: stats.TotalConns,
+		IdleConns:  stats.IdleConns,
+		StaleConns: stats.StaleConns,
+	}, nil
+}
+
+type startKey struct{}
+
+type routedKey struct{}
+
+// metricsHook reports every command and pipeline to Metrics
+type metricsHook struct {
+	metrics Metrics
+	// node is set on the node clients of a cluster, they only report the commands sent
+	// to them directly, such as SCAN, the cluster client reports those it routes
+	node bool
+}
+
+// start marks ctx with the start time, or as routed when the cluster client already did
+func (h *metricsHook) start(ctx context.Context) context.Context {
+	if h.node && ctx.Value(startKey{}) != nil {
+		return context.WithValue(ctx, routedKey{}, true)
+	}
+	return context.WithValue(ctx, startKey{}, time.Now())
+}
+
+func (h *metricsHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return h.start(ctx), nil
+}
+
+func (h *metricsHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	if ctx.Value(routedKey{}) != nil {
+		return nil
+	}
+	h.metrics.ObserveCommand(cmd.Name(), commandResult(cmd.Err()), elapsed(ctx))
+	h.observe(cmd)
+	return nil
+}
+
+func (h *metricsHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return h.start(ctx), nil
+}
+
+func (h *metricsHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	if ctx.Value(routedKey{}) != nil {
+		return nil
+	}
+	result := ResultOK
+	for _, cmd := range cmds {
+		if commandResult(cmd.Err()) == ResultError {
+			result = ResultError
+		}
+		h.observe(cmd)
+	}
+	h.metrics.ObserveCommand("pipeline", result, elapsed(ctx))
+	return nil
+}
+
+// observe records the lookups and payload sizes of cmd
+func (h *metricsHook) observe(cmd redis.Cmder) {
+	name := cmd.Name()
+	switch c := cmd.(type) {
+	case *redis.StringCmd:
+		if !readCommands[name] {
+			break
+		}
+		switch {
+		case c.Err() == redis.Nil:
+			h.metrics.ObserveLookups(name, 0, 1)
+		case c.Err() == nil:
+			h.metrics.ObserveLookups(name, 1, 0)
+			h.metrics.ObservePayloadSize(name, len(c.Val()))
+		}
+	case *redis.SliceCmd:
+		if c.Err() != nil || (name != "mget" && name != "hmget") {
+			break
+		}
+		hits, size := 0, 0
+		for _, value := range c.Val() {
+			if data, ok := value.(string); ok {
+				hits++
+				size += len(data)
+			}
+		}
+		h.metrics.ObserveLookups(name, hits, len(c.Val())-hits)
+		h.metrics.ObservePayloadSize(name, size)
+	case *redis.IntCmd:
+		if c.Err() != nil || name != "exists" {
+			// the writes replying with a count, such as HSET, record their payload below
+			break
+		}
+		found := int(c.Val())
+		h.metrics.ObserveLookups(name, found, len(c.Args())-1-found)
+	}
+
+	if writeCommands[name] && len(cmd.Args()) > 2 {
+		values := cmd.Args()[2:]
+		if name == "set" || name == "setnx" {
+			// the options following the value are not payload
+			values = values[:1]
+		}
+		size := 0
+		for _, arg := range values {
+			switch value := arg.(type) {
+			case string:
+				size += len(value)
+			case []byte:
+				size += len(value)
+			}
+		}
+		h.metrics.ObservePayloadSize(name, size)
+	}
+}
+
+// readCommands read a single value
+var readCommands = map[string]bool{
+	"get":    true,
+	"getset": true,
+	"hget":   true,
+	"lpop":   true,
+	"rpop":   true,
+}
+
+// writeCommands carry values after the key, their sizes are recorded as payload
+var writeCommands = map[string]bool{
+	"set":     true,
+	"setnx":   true,
+	"hset":    true,
+	"hmset":   true,
+	"lpush":   true,
+	"rpush":   true,
+	"sadd":    true,
+	"publish": true,
+}
+
+func commandResult(err error) string {
+	if err != nil && err != redis.Nil {
+		return ResultError
+	}
+	return ResultOK
+}
+
+func elapsed(ctx context.Context) time.Duration {
+	start, ok := ctx.Value(startKey{}).(time.Time)
+	if !ok {
+		return 0
+	}
+	return time.Since(start)
+}
@@ -0,0 +1,210 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v7"
+)
+
+type observedCommand struct {
+	command, result string
+	duration        time.Duration
+}
+
+type observedLookups struct {
+	command      string
+	hits, misses int
+}
+
+// recordingMetrics keeps every measurement it receives
+type recordingMetrics struct {
+	mu       sync.Mutex
+	commands []observedCommand
+	lookups  []observedLookups
+	payloads map[string]int
+}
+
+func (m *recordingMetrics) ObserveCommand(command, result string, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.commands = append(m.commands, observedCommand{command, result, duration})
+}
+
+func (m *recordingMetrics) ObserveLookups(command string, hits, misses int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lookups = append(m.lookups, observedLookups{command, hits, misses})
+}
+
+func (m *recordingMetrics) ObservePayloadSize(command string, size int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.payloads == nil {
+		m.payloads = map[string]int{}
+	}
+	m.payloads[command] += size
+}
+
+func (m *recordingMetrics) ObserveBreakerState(name string, state BreakerState) {}
+
+// reset returns the measurements received so far and forgets them
+func (m *recordingMetrics) reset() ([]observedCommand, []observedLookups, map[string]int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	commands, lookups, payloads := m.commands, m.lookups, m.payloads
+	m.commands, m.lookups, m.payloads = nil, nil, nil
+	return commands, lookups, payloads
+}
+
+func newMeteredClient(t *testing.T) (*redis.Client, *recordingMetrics) {
+	t.Helper()
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+	metrics := &recordingMetrics{}
+	opts := Options{Addrs: []string{server.Addr()}, Metrics: metrics}
+	client, err := initRedis(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	metrics.reset()
+	return client, metrics
+}
+
+func TestMetricsHookCommands(t *testing.T) {
+	client, metrics := newMeteredClient(t)
+	client.Set("a", "12345", 0)
+	client.Get("missing")
+	client.HSet("h", "f", "v")
+	client.Get("h")
+
+	commands, _, _ := metrics.reset()
+	want := []observedCommand{
+		{command: "set", result: ResultOK},
+		// a miss is not a failure
+		{command: "get", result: ResultOK},
+		{command: "hset", result: ResultOK},
+		// WRONGTYPE
+		{command: "get", result: ResultError},
+	}
+	if len(commands) != len(want) {
+		t.Fatalf("commands = %v, want %v", commands, want)
+	}
+	for i := range want {
+		if commands[i].command != want[i].command || commands[i].result != want[i].result {
+			t.Errorf("commands[%d] = %v, want %v", i, commands[i], want[i])
+		}
+		if commands[i].duration <= 0 {
+			t.Errorf("commands[%d] latency = %s, want > 0", i, commands[i].duration)
+		}
+	}
+
+	pipe := client.Pipeline()
+	pipe.Get("a")
+	pipe.Get("h")
+	pipe.Exec()
+	commands, lookups, _ := metrics.reset()
+	if len(commands) != 1 || commands[0].command != "pipeline" || commands[0].result != ResultError {
+		t.Errorf("pipeline commands = %v, want a single failed pipeline", commands)
+	}
+	if len(lookups) != 1 || lookups[0] != (observedLookups{"get", 1, 0}) {
+		t.Errorf("pipeline lookups = %v, want the hit of its GET", lookups)
+	}
+}
+
+func TestMetricsHookLookups(t *testing.T) {
+	client, metrics := newMeteredClient(t)
+	client.Set("a", "12345", 0)
+	client.Set("b", "678", time.Minute)
+	client.HSet("h", "f", "v")
+	metrics.reset()
+
+	client.Get("a")
+	client.Get("missing")
+	client.MGet("a", "missing", "b")
+	client.HMGet("h", "f", "g")
+	client.Exists("a", "b", "missing")
+	client.Incr("counter")
+
+	_, lookups, payloads := metrics.reset()
+	want := []observedLookups{
+		{"get", 1, 0},
+		{"get", 0, 1},
+		{"mget", 2, 1},
+		{"hmget", 1, 1},
+		{"exists", 2, 1},
+	}
+	if len(lookups) != len(want) {
+		t.Fatalf("lookups = %v, want %v", lookups, want)
+	}
+	for i := range want {
+		if lookups[i] != want[i] {
+			t.Errorf("lookups[%d] = %v, want %v", i, lookups[i], want[i])
+		}
+	}
+	if payloads["get"] != 5 || payloads["mget"] != 8 || payloads["hmget"] != 1 {
+		t.Errorf("read payloads = %v, want get 5, mget 8, hmget 1", payloads)
+	}
+}
+
+func TestMetricsHookPayloadSize(t *testing.T) {
+	client, metrics := newMeteredClient(t)
+	client.Set("a", "12345", time.Minute)
+	client.HSet("h", "f", "abc", "g", []byte("de"))
+	client.RPush("l", "x", "yz")
+	client.Del("a")
+
+	_, _, payloads := metrics.reset()
+	// the options of SET are not payload, the arguments after the key of the others are
+	want := map[string]int{"set": 5, "hset": 7, "rpush": 3}
+	for command, size := range want {
+		if payloads[command] != size {
+			t.Errorf("payload of %s = %d, want %d", command, payloads[command], size)
+		}
+	}
+	if _, ok := payloads["del"]; ok {
+		t.Error("DEL should not record a payload")
+	}
+}
+
+func TestMetricsHookClusterNodes(t *testing.T) {
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	metrics := &recordingMetrics{}
+	// both addresses are the same node which owns every slot
+	helper, err := NewCacheHelperWithOptions(Options{Addrs: []string{server.Addr(), server.Addr()}, Metrics: metrics})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	helper.Set(ctx, "a", "1", 0)
+	metrics.reset()
+
+	if err := helper.Exists(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := helper.GetKeysByPattern(ctx, "*", 0, 10); err != nil {
+		t.Fatal(err)
+	}
+	commands, _, _ := metrics.reset()
+	counts := map[string]int{}
+	for _, command := range commands {
+		counts[command.command]++
+	}
+	if counts["exists"] != 1 {
+		t.Errorf("EXISTS routed by the cluster was reported %d times, want once", counts["exists"])
+	}
+	if counts["scan"] != 1 {
+		t.Errorf("SCAN sent to a node was reported %d times, want once", counts["scan"])
+	}
+}
@@ -0,0 +1,243 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/binpossible49/go-libs/opentracing/jaeger"
+	"github.com/go-redis/redis/v7"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+var (
+	// tagKeyScript adds ARGV[2] to the tag set, the set lives at least as long as the
+	// keys it holds, ARGV[1] is the TTL of the key in milliseconds, 0 for none
+	tagKeyScript = newBuiltinScript("namespace.tagKey", `
+local existed = redis.call("EXISTS", KEYS[1])
+redis.call("SADD", KEYS[1], ARGV[2])
+local ttl = tonumber(ARGV[1])
+if ttl <= 0 then
+	redis.call("PERSIST", KEYS[1])
+	return 1
+end
+local current = redis.call("PTTL", KEYS[1])
+if existed == 0 or (current >= 0 and current < ttl) then
+	redis.call("PEXPIRE", KEYS[1], ttl)
+end
+return 1`)
+	// popTagScript removes the tag set and returns its keys, keys tagged afterwards
+	// go to a new set so that none is lost
+	popTagScript = newBuiltinScript("namespace.popTag", `
+local keys = redis.call("SMEMBERS", KEYS[1])
+redis.call("DEL", KEYS[1])
+return keys`)
+)
+
+// NamespacedCacheHelper is a CacheHelper whose keys live in a namespace and can be tagged
+type NamespacedCacheHelper interface {
+	CacheHelper
+	// SetWithTags sets key and records it under every tag
+	SetWithTags(ctx context.Context, key string, value interface{}, expiration time.Duration, tags ...string) error
+	// InvalidateTag deletes every key recorded under the tags
+	InvalidateTag(ctx context.Context, tags ...string) error
+}
+
+// NamespaceOptions represents options of the namespaced CacheHelper
+type NamespaceOptions struct {
+	Service     string
+	Environment string
+	// Version is the schema version of the values, bumping it makes every key of the
+	// previous version unreachable at once, they are left to expire
+	Version int
+}
+
+type namespacedCacheHelper struct {
+	inner     CacheHelper
+	client    redis.UniversalClient
+	prefix    string
+	tagPrefix string
+}
+
+// NewNamespacedCacheHelper creates an instance prefixing every key with service:environment:v<version>:.
+// Tags are stored in redis sets under service:environment:tags:v<version>:, outside the keys
+// of the namespace, they are not supported when inner has no redis behind it.
+func NewNamespacedCacheHelper(inner CacheHelper, opts NamespaceOptions) (NamespacedCacheHelper, error) {
+	if opts.Service == "" {
+		return nil, errors.New("cache: namespace service is required")
+	}
+	base := opts.Service + ":"
+	if opts.Environment != "" {
+		base += opts.Environment + ":"
+	}
+	version := "v" + strconv.Itoa(opts.Version) + ":"
+
+	// tags are optional, the client is only needed by them
+	client, _ := redisClientOf(inner)
+	return &namespacedCacheHelper{
+		inner:     inner,
+		client:    client,
+		prefix:    base + version,
+		tagPrefix: base + "tags:" + version,
+	}, nil
+}
+
+func (h *namespacedCacheHelper) unwrap() CacheHelper {
+	return h.inner
+}
+
+func (h *namespacedCacheHelper) key(key string) string {
+	return h.prefix + key
+}
+
+func (h *namespacedCacheHelper) keys(keys []string) []string {
+	namespaced := make([]string, len(keys))
+	for i, key := range keys {
+		namespaced[i] = h.key(key)
+	}
+	return namespaced
+}
+
+func (h *namespacedCacheHelper) tagKey(tag string) string {
+	return h.tagPrefix + tag
+}
+
+func (h *namespacedCacheHelper) Exists(ctx context.Context, key string) error {
+	return h.inner.Exists(ctx, h.key(key))
+}
+
+func (h *namespacedCacheHelper) Get(ctx context.Context, key string, value interface{}) error {
+	return h.inner.Get(ctx, h.key(key), value)
+}
+
+func (h *namespacedCacheHelper) GetInterface(ctx context.Context, key string, value interface{}) (interface{}, error) {
+	return h.inner.GetInterface(ctx, h.key(key), value)
+}
+
+func (h *namespacedCacheHelper) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	return h.inner.Set(ctx, h.key(key), value, expiration)
+}
+
+func (h *namespacedCacheHelper) Del(ctx context.Context, key string) error {
+	return h.inner.Del(ctx, h.key(key))
+}
+
+func (h *namespacedCacheHelper) Expire(ctx context.Context, key string, expiration time.Duration) error {
+	return h.inner.Expire(ctx, h.key(key), expiration)
+}
+
+func (h *namespacedCacheHelper) DelMulti(ctx context.Context, keys ...string) error {
+	return h.inner.DelMulti(ctx, h.keys(keys)...)
+}
+
+// GetKeysByPattern matches pattern inside the namespace and returns keys without the prefix
+func (h *namespacedCacheHelper) GetKeysByPattern(ctx context.Context, pattern string, cursor uint64, limit int64) ([]string, uint64, error) {
+	if pattern == "" {
+		pattern = "*"
+	}
+	keys, next, err := h.inner.GetKeysByPattern(ctx, escapePattern(h.prefix)+pattern, cursor, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	for i, key := range keys {
+		keys[i] = strings.TrimPrefix(key, h.prefix)
+	}
+	return keys, next, nil
+}
+
+func (h *namespacedCacheHelper) MGet(ctx context.Context, keys []string, values interface{}) error {
+	target := reflect.ValueOf(values)
+	if target.Kind() != reflect.Ptr || target.IsNil() || target.Elem().Kind() != reflect.Map {
+		return MGet(ctx, h.inner, h.keys(keys), values)
+	}
+
+	// a map result is keyed by the namespaced keys, it is re-keyed before being handed over
+	namespaced := reflect.New(target.Elem().Type())
+	if err := MGet(ctx, h.inner, h.keys(keys), namespaced.Interface()); err != nil {
+		return err
+	}
+	target = target.Elem()
+	if target.IsNil() {
+		target.Set(reflect.MakeMapWithSize(target.Type(), len(keys)))
+	}
+	iter := namespaced.Elem().MapRange()
+	for iter.Next() {
+		key := strings.TrimPrefix(iter.Key().String(), h.prefix)
+		target.SetMapIndex(reflect.ValueOf(key).Convert(target.Type().Key()), iter.Value())
+	}
+	return nil
+}
+
+func (h *namespacedCacheHelper) MSet(ctx context.Context, items ...Item) error {
+	namespaced := make([]Item, len(items))
+	for i, item := range items {
+		namespaced[i] = Item{Key: h.key(item.Key), Value: item.Value, Expiration: item.Expiration}
+	}
+	return MSet(ctx, h.inner, namespaced...)
+}
+
+func (h *namespacedCacheHelper) SetWithTags(ctx context.Context, key string, value interface{}, expiration time.Duration, tags ...string) (err error) {
+	span := jaeger.Start(ctx, ">helper.namespacedCacheHelper/SetWithTags", ext.SpanKindRPCClient, opentracing.Tag{Key: "cache.tags", Value: strings.Join(tags, ",")})
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	if len(tags) > 0 && h.client == nil {
+		return ErrUnsupportedHelper
+	}
+	// tags are recorded first, a tag pointing to a missing key is harmless
+	// while a key missing from its tag would survive the invalidation
+	for _, tag := range tags {
+		err = tagKeyScript.Run(withContext(ctx, h.client), []string{h.tagKey(tag)}, expiration.Milliseconds(), h.key(key)).Err()
+		if err != nil {
+			return err
+		}
+	}
+	return h.inner.Set(ctx, h.key(key), value, expiration)
+}
+
+func (h *namespacedCacheHelper) InvalidateTag(ctx context.Context, tags ...string) (err error) {
+	span := jaeger.Start(ctx, ">helper.namespacedCacheHelper/InvalidateTag", ext.SpanKindRPCClient, opentracing.Tag{Key: "cache.tags", Value: strings.Join(tags, ",")})
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	if h.client == nil {
+		return ErrUnsupportedHelper
+	}
+	var keys []string
+	for _, tag := range tags {
+		tagged, err := popTagScript.Run(withContext(ctx, h.client), []string{h.tagKey(tag)}).Result()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		members, _ := tagged.([]interface{})
+		for _, member := range members {
+			if key, ok := member.(string); ok {
+				keys = append(keys, key)
+			}
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	span.SetTag("cache.keys", len(keys))
+	return h.inner.DelMulti(ctx, keys...)
+}
+
+// escapePattern escapes the glob characters of s so that it only matches itself
+func escapePattern(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
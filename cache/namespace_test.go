@@ -0,0 +1,94 @@
+package cache
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNamespacedCacheHelper(t *testing.T) {
+	ctx := context.Background()
+	inner := NewMemoryCacheHelper(nil)
+	v1, err := NewNamespacedCacheHelper(inner, NamespaceOptions{Service: "orders", Environment: "prod", Version: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2, _ := NewNamespacedCacheHelper(inner, NamespaceOptions{Service: "orders", Environment: "prod", Version: 2})
+
+	v1.Set(ctx, "order:1", "a", 0)
+	if err := inner.Exists(ctx, "orders:prod:v1:order:1"); err != nil {
+		t.Errorf("key should be stored with the namespace prefix: %v", err)
+	}
+	if err := v2.Exists(ctx, "order:1"); err == nil {
+		t.Errorf("key of v1 should not be visible from v2")
+	}
+
+	values := map[string]string{}
+	if err := MGet(ctx, v1, []string{"order:1", "order:2"}, &values); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"order:1": "a"}; !reflect.DeepEqual(values, want) {
+		t.Errorf("MGet() = %v, want %v", values, want)
+	}
+
+	keys, _, err := v1.GetKeysByPattern(ctx, "order:*", 0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"order:1"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("GetKeysByPattern() = %v, want %v", keys, want)
+	}
+
+	if err := v1.SetWithTags(ctx, "order:3", "c", 0, "customer:42"); err != ErrUnsupportedHelper {
+		t.Errorf("SetWithTags() without redis = %v, want ErrUnsupportedHelper", err)
+	}
+}
+
+func TestEscapePattern(t *testing.T) {
+	if got := escapePattern(`a*b?[c]\`); got != `a\*b\?\[c\]\\` {
+		t.Errorf("escapePattern() = %q", got)
+	}
+}
+
+func TestNamespacedInvalidateTag(t *testing.T) {
+	ctx := context.Background()
+	server, inner := newTestRedisHelper(t)
+	h, err := NewNamespacedCacheHelper(inner, NamespaceOptions{Service: "orders", Environment: "prod", Version: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.SetWithTags(ctx, "order:1", "a", time.Minute, "customer:42"); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.SetWithTags(ctx, "order:2", "b", 0, "customer:42", "day:1"); err != nil {
+		t.Fatal(err)
+	}
+	h.SetWithTags(ctx, "order:3", "c", 0, "customer:7")
+	// a user key that looks like a tag set is left alone
+	h.Set(ctx, "tag:customer:42", "user value", 0)
+
+	if ttl := server.TTL("orders:prod:tags:v1:customer:42"); ttl != 0 {
+		t.Errorf("TTL of a tag holding a key without expiration = %v, want none", ttl)
+	}
+	if err := h.InvalidateTag(ctx, "customer:42"); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"order:1", "order:2"} {
+		if err := h.Exists(ctx, key); err == nil {
+			t.Errorf("%s should be deleted with its tag", key)
+		}
+	}
+	for _, key := range []string{"order:3", "tag:customer:42"} {
+		if err := h.Exists(ctx, key); err != nil {
+			t.Errorf("%s should be kept: %v", key, err)
+		}
+	}
+	if server.Exists("orders:prod:tags:v1:customer:42") {
+		t.Error("tag set should be deleted")
+	}
+	if err := h.InvalidateTag(ctx, "unknown"); err != nil {
+		t.Errorf("InvalidateTag() of an unknown tag = %v", err)
+	}
+}
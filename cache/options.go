@@ -0,0 +1,141 @@
+package cache
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+// Options represents connection options of CacheHelper
+type Options struct {
+	// Addrs holds one address for a single node or several for a cluster,
+	// they are the sentinel addresses when MasterName is set
+	Addrs []string
+	// MasterName enables sentinel mode with the master monitored under this name
+	MasterName string
+	// Username is the ACL user (redis 6+), Password alone uses the legacy AUTH
+	Username string
+	Password string
+	// DB is the database index, it must be 0 for a cluster
+	DB int
+	// TLS enables TLS when not nil
+	TLS *TLSOptions
+
+	PoolSize     int
+	MinIdleConns int
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	// OperationTimeout bounds every command when the context of the call has no earlier
+	// deadline, 3s by default and none when negative, blocking commands are not bounded
+	OperationTimeout time.Duration
+	// CommandTimeouts overrides OperationTimeout for commands by name, e.g. "scan"
+	CommandTimeouts map[string]time.Duration
+	// Metrics receives the measurements of every command when not nil
+	Metrics Metrics
+
+	// Serializer encodes values, JSONSerializer by default
+	Serializer Serializer
+	// Compression compresses values of at least CompressionThreshold bytes (1KB by default)
+	Compression          Compression
+	CompressionThreshold int
+}
+
+// TLSOptions represents TLS options of a redis connection
+type TLSOptions struct {
+	// CAFile is a PEM file of the CA used to verify the server, system roots are used when both CA options are empty
+	CAFile string
+	// CAPEM is the PEM content of the CA, it is appended to CAFile
+	CAPEM              []byte
+	ServerName         string
+	InsecureSkipVerify bool
+}
+
+func (o *Options) validate() error {
+	if len(o.Addrs) == 0 {
+		return errors.New("cache: at least one address is required")
+	}
+	if o.MasterName == "" && len(o.Addrs) > 1 && o.DB != 0 {
+		return errors.New("cache: redis cluster only supports DB 0")
+	}
+	if o.Username != "" && o.Password == "" {
+		return errors.New("cache: password is required with username")
+	}
+	return nil
+}
+
+func (o *Options) tlsConfig() (*tls.Config, error) {
+	if o.TLS == nil {
+		return nil, nil
+	}
+	cfg := &tls.Config{
+		ServerName:         o.TLS.ServerName,
+		InsecureSkipVerify: o.TLS.InsecureSkipVerify,
+	}
+	if o.TLS.CAFile == "" && len(o.TLS.CAPEM) == 0 {
+		return cfg, nil
+	}
+
+	pool := x509.NewCertPool()
+	if o.TLS.CAFile != "" {
+		pem, err := ioutil.ReadFile(o.TLS.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("cache: read CA file: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("cache: no certificate found in %s", o.TLS.CAFile)
+		}
+	}
+	if len(o.TLS.CAPEM) > 0 && !pool.AppendCertsFromPEM(o.TLS.CAPEM) {
+		return nil, errors.New("cache: no certificate found in CA PEM")
+	}
+	cfg.RootCAs = pool
+	return cfg, nil
+}
+
+// onConnect authenticates with an ACL user, go-redis only sends AUTH <password>
+// so both AUTH and SELECT are issued here when a username is configured
+func (o *Options) onConnect() func(*redis.Conn) error {
+	if o.Username == "" {
+		return nil
+	}
+	username, password, db := o.Username, o.Password, o.DB
+	return func(conn *redis.Conn) error {
+		if err := conn.Process(redis.NewStatusCmd("auth", username, password)); err != nil {
+			return err
+		}
+		if db > 0 {
+			return conn.Select(db).Err()
+		}
+		return nil
+	}
+}
+
+// addHooks installs the hooks every client of the helper needs
+func (o *Options) addHooks(client interface{ AddHook(redis.Hook) }) {
+	client.AddHook(newTimeoutHook(*o))
+	if o.Metrics != nil {
+		client.AddHook(&metricsHook{metrics: o.Metrics})
+	}
+}
+
+// password returns the password go-redis should send itself
+func (o *Options) password() string {
+	if o.Username != "" {
+		return ""
+	}
+	return o.Password
+}
+
+// db returns the database go-redis should select itself
+func (o *Options) db() int {
+	if o.Username != "" {
+		return 0
+	}
+	return o.DB
+}
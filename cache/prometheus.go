@@ -0,0 +1,150 @@
+package cache
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// PrometheusMetrics exports Metrics to prometheus, the hit ratio of a command is
+// rate(<namespace>_cache_lookups_total{result="hit"}) over rate(<namespace>_cache_lookups_total)
+type PrometheusMetrics struct {
+	registerer prometheus.Registerer
+	namespace  string
+
+	commands *prometheus.CounterVec
+	latency  *prometheus.HistogramVec
+	lookups  *prometheus.CounterVec
+	payload  *prometheus.HistogramVec
+	breaker  *prometheus.GaugeVec
+}
+
+// NewPrometheusMetrics creates an instance registering its collectors with registerer
+func NewPrometheusMetrics(registerer prometheus.Registerer, namespace string) (*PrometheusMetrics, error) {
+	m := &PrometheusMetrics{
+		registerer: registerer,
+		namespace:  namespace,
+		commands: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "cache",
+			Name:      "commands_total",
+			Help:      "Redis commands sent, by command and result.",
+		}, []string{"command", "result"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "cache",
+			Name:      "command_duration_seconds",
+			Help:      "Latency of redis commands.",
+			Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+		}, []string{"command"}),
+		lookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "cache",
+			Name:      "lookups_total",
+			Help:      "Keys read, by command and hit or miss.",
+		}, []string{"command", "result"}),
+		payload: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "cache",
+			Name:      "payload_bytes",
+			Help:      "Size of the values written or read.",
+			Buckets:   prometheus.ExponentialBuckets(64, 4, 8),
+		}, []string{"command"}),
+		breaker: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "cache",
+			Name:      "breaker_state",
+			Help:      "State of the circuit breakers, 0 closed, 1 open, 2 half-open.",
+		}, []string{"breaker"}),
+	}
+	for _, collector := range []prometheus.Collector{m.commands, m.latency, m.lookups, m.payload, m.breaker} {
+		if err := registerer.Register(collector); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// ObserveCommand counts the command by result and records its latency
+func (m *PrometheusMetrics) ObserveCommand(command, result string, duration time.Duration) {
+	m.commands.WithLabelValues(command, result).Inc()
+	m.latency.WithLabelValues(command).Observe(duration.Seconds())
+}
+
+// ObserveLookups adds the hits and misses of the command to the lookups counter
+func (m *PrometheusMetrics) ObserveLookups(command string, hits, misses int) {
+	if hits > 0 {
+		m.lookups.WithLabelValues(command, ResultHit).Add(float64(hits))
+	}
+	if misses > 0 {
+		m.lookups.WithLabelValues(command, ResultMiss).Add(float64(misses))
+	}
+}
+
+// ObservePayloadSize records the payload size of the command in the size histogram
+func (m *PrometheusMetrics) ObservePayloadSize(command string, size int) {
+	m.payload.WithLabelValues(command).Observe(float64(size))
+}
+
+// ObserveBreakerState sets the state gauge of the breaker, 0 closed, 1 open and 2 half-open
+func (m *PrometheusMetrics) ObserveBreakerState(name string, state BreakerState) {
+	m.breaker.WithLabelValues(name).Set(float64(state))
+}
+
+// CollectPoolStats exports the connection pool statistics of helper labeled with pool,
+// they are read on every scrape
+func (m *PrometheusMetrics) CollectPoolStats(pool string, helper CacheHelper) error {
+	if _, err := PoolStatsOf(helper); err != nil {
+		return err
+	}
+	return m.registerer.Register(&poolStatsCollector{
+		helper: helper,
+		labels: prometheus.Labels{"pool": pool},
+		descs:  newPoolStatsDescs(m.namespace, prometheus.Labels{"pool": pool}),
+	})
+}
+
+type poolStatsDescs struct {
+	hits, misses, timeouts, total, idle, stale *prometheus.Desc
+}
+
+func newPoolStatsDescs(namespace string, labels prometheus.Labels) poolStatsDescs {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "cache_pool", name), help, nil, labels)
+	}
+	return poolStatsDescs{
+		hits:     desc("hits_total", "Times a free connection was found in the pool."),
+		misses:   desc("misses_total", "Times a free connection was not found in the pool."),
+		timeouts: desc("timeouts_total", "Times a connection could not be obtained in time."),
+		total:    desc("connections", "Connections in the pool."),
+		idle:     desc("idle_connections", "Idle connections in the pool."),
+		stale:    desc("stale_connections_total", "Stale connections removed from the pool."),
+	}
+}
+
+type poolStatsCollector struct {
+	helper CacheHelper
+	labels prometheus.Labels
+	descs  poolStatsDescs
+}
+
+func (c *poolStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{c.descs.hits, c.descs.misses, c.descs.timeouts, c.descs.total, c.descs.idle, c.descs.stale} {
+		ch <- desc
+	}
+}
+
+func (c *poolStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	stats, err := PoolStatsOf(c.helper)
+	if err != nil {
+		zap.S().Warnw("Failed to read cache pool stats", "pool", c.labels["pool"], zap.Error(err))
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(c.descs.hits, prometheus.CounterValue, float64(stats.Hits))
+	ch <- prometheus.MustNewConstMetric(c.descs.misses, prometheus.CounterValue, float64(stats.Misses))
+	ch <- prometheus.MustNewConstMetric(c.descs.timeouts, prometheus.CounterValue, float64(stats.Timeouts))
+	ch <- prometheus.MustNewConstMetric(c.descs.total, prometheus.GaugeValue, float64(stats.TotalConns))
+	ch <- prometheus.MustNewConstMetric(c.descs.idle, prometheus.GaugeValue, float64(stats.IdleConns))
+	ch <- prometheus.MustNewConstMetric(c.descs.stale, prometheus.CounterValue, float64(stats.StaleConns))
+}
@@ -0,0 +1,38 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestPrometheusMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	m, err := NewPrometheusMetrics(registry, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.ObserveCommand("get", ResultOK, time.Millisecond)
+	m.ObserveLookups("mget", 2, 1)
+	m.ObserveBreakerState("sessions", BreakerOpen)
+	m.ObserveBreakerState("default", BreakerClosed)
+
+	if got := testutil.ToFloat64(m.commands.WithLabelValues("get", ResultOK)); got != 1 {
+		t.Errorf("commands_total = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(m.lookups.WithLabelValues("mget", ResultMiss)); got != 1 {
+		t.Errorf("lookups_total{result=miss} = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(m.breaker.WithLabelValues("sessions")); got != float64(BreakerOpen) {
+		t.Errorf("breaker_state{breaker=sessions} = %v, want %v", got, float64(BreakerOpen))
+	}
+	if got := testutil.ToFloat64(m.breaker.WithLabelValues("default")); got != float64(BreakerClosed) {
+		t.Errorf("breaker_state{breaker=default} = %v, want %v", got, float64(BreakerClosed))
+	}
+
+	if err := m.CollectPoolStats("memory", NewMemoryCacheHelper(nil)); err != ErrUnsupportedHelper {
+		t.Errorf("CollectPoolStats() without redis = %v, want ErrUnsupportedHelper", err)
+	}
+}
@@ -0,0 +1,249 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/binpossible49/go-libs/opentracing/jaeger"
+	"github.com/go-redis/redis/v7"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+const defaultRateLimitKeyPrefix = "rate:"
+
+// The scripts return {allowed, remaining, retry after ms, reset after ms}.
+// Time is read from redis so that instances with skewed clocks share the same window.
+var (
+	fixedWindowScript = newBuiltinScript("rateLimit.fixedWindow", `
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local cost = tonumber(ARGV[3])
+local current = tonumber(redis.call("GET", KEYS[1]) or "0")
+local ttl = redis.call("PTTL", KEYS[1])
+if ttl < 0 then
+	ttl = window
+end
+if current + cost > limit then
+	local retry = ttl
+	if cost > limit then
+		retry = -1
+	end
+	return {0, limit - current, retry, ttl}
+end
+current = redis.call("INCRBY", KEYS[1], cost)
+if current == cost then
+	redis.call("PEXPIRE", KEYS[1], window)
+end
+return {1, limit - current, 0, ttl}`)
+
+	slidingWindowScript = newBuiltinScript("rateLimit.slidingWindow", `
+redis.replicate_commands()
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local cost = tonumber(ARGV[3])
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now - window)
+local count = redis.call("ZCARD", KEYS[1])
+if count + cost > limit then
+	local retry = -1
+	if cost <= limit then
+		local index = count + cost - limit - 1
+		local oldest = redis.call("ZRANGE", KEYS[1], index, index, "WITHSCORES")
+		retry = tonumber(oldest[2]) + window - now
+	end
+	local reset = 0
+	local newest = redis.call("ZRANGE", KEYS[1], -1, -1, "WITHSCORES")
+	if newest[2] then
+		reset = tonumber(newest[2]) + window - now
+	end
+	return {0, limit - count, retry, reset}
+end
+for i = 1, cost do
+	redis.call("ZADD", KEYS[1], now, ARGV[4] .. ":" .. i)
+end
+redis.call("PEXPIRE", KEYS[1], window)
+return {1, limit - count - cost, 0, window}`)
+
+	gcraScript = newBuiltinScript("rateLimit.gcra", `
+redis.replicate_commands()
+local burst = tonumber(ARGV[1])
+local emission = tonumber(ARGV[2])
+local cost = tonumber(ARGV[3])
+local t = redis.call("TIME")
+local now = (tonumber(t[1]) - 1483228800) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local tat = tonumber(redis.call("GET", KEYS[1]) or now)
+if tat < now then
+	tat = now
+end
+local newTat = tat + emission * cost
+local allowAt = newTat - emission * burst
+if allowAt > now then
+	local retry = math.ceil(allowAt - now)
+	if cost > burst then
+		retry = -1
+	end
+	return {0, math.floor((now - (tat - emission * burst)) / emission), retry, math.ceil(tat - now)}
+end
+local reset = math.ceil(newTat - now)
+redis.call("SET", KEYS[1], newTat, "PX", reset)
+return {1, math.floor((now - allowAt) / emission), 0, reset}`)
+)
+
+// RateLimitResult is the outcome of a rate limit check
+type RateLimitResult struct {
+	Allowed   bool
+	Remaining int64
+	// RetryAfter is when the request would be allowed, negative if it never will
+	// because it costs more than the limit
+	RetryAfter time.Duration
+	// ResetAfter is when the limiter is back to its full capacity
+	ResetAfter time.Duration
+}
+
+// RateLimiter is helper of rate limiting shared by all instances
+type RateLimiter interface {
+	Allow(ctx context.Context, key string) (*RateLimitResult, error)
+	AllowN(ctx context.Context, key string, n int64) (*RateLimitResult, error)
+}
+
+// RateLimit represents a limit of requests per period
+type RateLimit struct {
+	Limit  int64
+	Period time.Duration
+	// Burst is how many requests GCRA accepts at once, it defaults to Limit
+	Burst int64
+	// KeyPrefix is prepended to limited keys
+	KeyPrefix string
+}
+
+type rateLimiter struct {
+	name   string
+	client redis.UniversalClient
+	script *redis.Script
+	limit  RateLimit
+	args   func(n int64) []interface{}
+}
+
+// NewFixedWindowRateLimiter creates an instance counting requests in fixed windows of limit.Period
+func NewFixedWindowRateLimiter(helper CacheHelper, limit RateLimit) (RateLimiter, error) {
+	l, err := newRateLimiter("fixedWindow", helper, fixedWindowScript, limit)
+	if err != nil {
+		return nil, err
+	}
+	l.args = func(n int64) []interface{} {
+		return []interface{}{limit.Limit, int64(limit.Period / time.Millisecond), n}
+	}
+	return l, nil
+}
+
+// NewSlidingWindowRateLimiter creates an instance logging every request of the last limit.Period,
+// it is exact but stores one entry per request
+func NewSlidingWindowRateLimiter(helper CacheHelper, limit RateLimit) (RateLimiter, error) {
+	l, err := newRateLimiter("slidingWindow", helper, slidingWindowScript, limit)
+	if err != nil {
+		return nil, err
+	}
+	l.args = func(n int64) []interface{} {
+		return []interface{}{limit.Limit, int64(limit.Period / time.Millisecond), n, randomMember()}
+	}
+	return l, nil
+}
+
+// NewGCRARateLimiter creates an instance implementing the generic cell rate algorithm,
+// a token bucket refilled with limit.Limit tokens per limit.Period holding up to limit.Burst
+func NewGCRARateLimiter(helper CacheHelper, limit RateLimit) (RateLimiter, error) {
+	if limit.Burst <= 0 {
+		limit.Burst = limit.Limit
+	}
+	l, err := newRateLimiter("gcra", helper, gcraScript, limit)
+	if err != nil {
+		return nil, err
+	}
+	emission := float64(limit.Period/time.Millisecond) / float64(limit.Limit)
+	l.args = func(n int64) []interface{} {
+		return []interface{}{limit.Burst, emission, n}
+	}
+	return l, nil
+}
+
+func newRateLimiter(name string, helper CacheHelper, script *redis.Script, limit RateLimit) (*rateLimiter, error) {
+	if limit.Limit <= 0 || limit.Period < time.Millisecond {
+		return nil, errors.New("cache: rate limit requires a positive limit and a period of at least 1ms")
+	}
+	client, err := redisClientOf(helper)
+	if err != nil {
+		return nil, err
+	}
+	if limit.KeyPrefix == "" {
+		limit.KeyPrefix = defaultRateLimitKeyPrefix
+	}
+	return &rateLimiter{
+		name:   name,
+		client: client,
+		script: script,
+		limit:  limit,
+	}, nil
+}
+
+func (l *rateLimiter) Allow(ctx context.Context, key string) (*RateLimitResult, error) {
+	return l.AllowN(ctx, key, 1)
+}
+
+func (l *rateLimiter) AllowN(ctx context.Context, key string, n int64) (result *RateLimitResult, err error) {
+	span := jaeger.Start(ctx, ">helper.rateLimiter/"+l.name, ext.SpanKindRPCClient, opentracing.Tag{Key: "ratelimit.key", Value: key})
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+	if n <= 0 {
+		return nil, errors.New("cache: rate limit cost must be positive")
+	}
+
+	values, err := l.script.Run(withContext(ctx, l.client), []string{l.limit.KeyPrefix + key}, l.args(n)...).Result()
+	if err != nil {
+		return nil, err
+	}
+	result, err = parseRateLimitResult(values)
+	if err != nil {
+		return nil, err
+	}
+	span.SetTag("ratelimit.allowed", result.Allowed)
+	return result, nil
+}
+
+func parseRateLimitResult(values interface{}) (*RateLimitResult, error) {
+	fields, ok := values.([]interface{})
+	if !ok || len(fields) != 4 {
+		return nil, errors.New("cache: unexpected rate limit script result")
+	}
+	ints := make([]int64, len(fields))
+	for i, field := range fields {
+		if ints[i], ok = field.(int64); !ok {
+			return nil, errors.New("cache: unexpected rate limit script result")
+		}
+	}
+	result := &RateLimitResult{
+		Allowed:    ints[0] == 1,
+		Remaining:  ints[1],
+		RetryAfter: time.Duration(ints[2]) * time.Millisecond,
+		ResetAfter: time.Duration(ints[3]) * time.Millisecond,
+	}
+	if result.Remaining < 0 {
+		result.Remaining = 0
+	}
+	if ints[2] < 0 {
+		result.RetryAfter = -1
+	}
+	return result, nil
+}
+
+// randomMember makes sliding window entries unique when several share a millisecond
+func randomMember() string {
+	token, err := randomToken()
+	if err != nil {
+		return time.Now().String()
+	}
+	return token
+}
@@ -0,0 +1,155 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseRateLimitResult(t *testing.T) {
+	tests := []struct {
+		name   string
+		values interface{}
+		want   RateLimitResult
+		err    bool
+	}{
+		{"allowed", []interface{}{int64(1), int64(4), int64(0), int64(1000)}, RateLimitResult{Allowed: true, Remaining: 4, ResetAfter: time.Second}, false},
+		{"denied", []interface{}{int64(0), int64(0), int64(250), int64(1000)}, RateLimitResult{RetryAfter: 250 * time.Millisecond, ResetAfter: time.Second}, false},
+		{"never", []interface{}{int64(0), int64(3), int64(-1), int64(1000)}, RateLimitResult{Remaining: 3, RetryAfter: -1, ResetAfter: time.Second}, false},
+		{"negative remaining", []interface{}{int64(0), int64(-2), int64(10), int64(10)}, RateLimitResult{RetryAfter: 10 * time.Millisecond, ResetAfter: 10 * time.Millisecond}, false},
+		{"short", []interface{}{int64(1), int64(4)}, RateLimitResult{}, true},
+		{"not an integer", []interface{}{"1", int64(4), int64(0), int64(0)}, RateLimitResult{}, true},
+		{"not a list", int64(1), RateLimitResult{}, true},
+	}
+	for _, tt := range tests {
+		result, err := parseRateLimitResult(tt.values)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: parseRateLimitResult() = %+v, want an error", tt.name, result)
+			}
+			continue
+		}
+		if err != nil || *result != tt.want {
+			t.Errorf("%s: parseRateLimitResult() = %+v, %v, want %+v", tt.name, result, err, tt.want)
+		}
+	}
+}
+
+func TestFixedWindowRateLimiter(t *testing.T) {
+	ctx := context.Background()
+	server, helper := newTestRedisHelper(t)
+	limiter, err := NewFixedWindowRateLimiter(helper, RateLimit{Limit: 3, Period: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := int64(2); i >= 0; i-- {
+		result, err := limiter.Allow(ctx, "user")
+		if err != nil || !result.Allowed || result.Remaining != i {
+			t.Fatalf("Allow() = %+v, %v, want allowed with %d remaining", result, err, i)
+		}
+	}
+	result, err := limiter.Allow(ctx, "user")
+	if err != nil || result.Allowed {
+		t.Fatalf("Allow() over the limit = %+v, %v", result, err)
+	}
+	if result.RetryAfter <= 0 || result.RetryAfter > time.Second {
+		t.Errorf("RetryAfter = %v, want the rest of the window", result.RetryAfter)
+	}
+	if result, _ := limiter.AllowN(ctx, "other", 4); result.Allowed || result.RetryAfter != -1 {
+		t.Errorf("AllowN() above the limit = %+v, want never allowed", result)
+	}
+
+	server.FastForward(time.Second)
+	if result, err := limiter.Allow(ctx, "user"); err != nil || !result.Allowed {
+		t.Errorf("Allow() in the next window = %+v, %v", result, err)
+	}
+}
+
+func TestSlidingWindowRateLimiter(t *testing.T) {
+	ctx := context.Background()
+	server, helper := newTestRedisHelper(t)
+	now := time.Now()
+	server.SetTime(now)
+	limiter, err := NewSlidingWindowRateLimiter(helper, RateLimit{Limit: 2, Period: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result, err := limiter.Allow(ctx, "user"); err != nil || !result.Allowed || result.Remaining != 1 {
+		t.Fatalf("Allow() = %+v, %v", result, err)
+	}
+	server.SetTime(now.Add(400 * time.Millisecond))
+	if result, err := limiter.Allow(ctx, "user"); err != nil || !result.Allowed || result.Remaining != 0 {
+		t.Fatalf("Allow() = %+v, %v", result, err)
+	}
+
+	// the oldest request leaves the window 1s after it was made
+	server.SetTime(now.Add(700 * time.Millisecond))
+	result, err := limiter.Allow(ctx, "user")
+	if err != nil || result.Allowed {
+		t.Fatalf("Allow() over the limit = %+v, %v", result, err)
+	}
+	if result.RetryAfter != 300*time.Millisecond {
+		t.Errorf("RetryAfter = %v, want 300ms", result.RetryAfter)
+	}
+	if result.ResetAfter != 700*time.Millisecond {
+		t.Errorf("ResetAfter = %v, want 700ms", result.ResetAfter)
+	}
+	// two more requests have to wait for both entries to leave
+	if result, _ := limiter.AllowN(ctx, "user", 2); result.RetryAfter != 700*time.Millisecond {
+		t.Errorf("AllowN(2) RetryAfter = %v, want 700ms", result.RetryAfter)
+	}
+
+	server.SetTime(now.Add(1001 * time.Millisecond))
+	if result, err := limiter.Allow(ctx, "user"); err != nil || !result.Allowed {
+		t.Errorf("Allow() once the oldest left = %+v, %v", result, err)
+	}
+}
+
+func TestGCRARateLimiter(t *testing.T) {
+	ctx := context.Background()
+	server, helper := newTestRedisHelper(t)
+	now := time.Now()
+	server.SetTime(now)
+	// a token every 100ms, up to 2 at once
+	limiter, err := NewGCRARateLimiter(helper, RateLimit{Limit: 10, Period: time.Second, Burst: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if result, err := limiter.Allow(ctx, "user"); err != nil || !result.Allowed {
+			t.Fatalf("Allow() within the burst = %+v, %v", result, err)
+		}
+	}
+	result, err := limiter.Allow(ctx, "user")
+	if err != nil || result.Allowed {
+		t.Fatalf("Allow() after the burst = %+v, %v", result, err)
+	}
+	if result.RetryAfter != 100*time.Millisecond {
+		t.Errorf("RetryAfter = %v, want one emission interval", result.RetryAfter)
+	}
+	if result.ResetAfter != 200*time.Millisecond {
+		t.Errorf("ResetAfter = %v, want two emission intervals", result.ResetAfter)
+	}
+	if result, _ := limiter.AllowN(ctx, "user", 3); result.RetryAfter != -1 {
+		t.Errorf("AllowN() above the burst RetryAfter = %v, want never", result.RetryAfter)
+	}
+
+	server.SetTime(now.Add(100 * time.Millisecond))
+	if result, err := limiter.Allow(ctx, "user"); err != nil || !result.Allowed {
+		t.Errorf("Allow() after an emission interval = %+v, %v", result, err)
+	}
+}
+
+func TestRateLimiterValidation(t *testing.T) {
+	_, helper := newTestRedisHelper(t)
+	if _, err := NewFixedWindowRateLimiter(helper, RateLimit{Limit: 0, Period: time.Second}); err == nil {
+		t.Error("NewFixedWindowRateLimiter() without limit = nil error")
+	}
+	limiter, _ := NewGCRARateLimiter(helper, RateLimit{Limit: 1, Period: time.Second})
+	if _, err := limiter.AllowN(context.Background(), "user", 0); err == nil {
+		t.Error("AllowN(0) = nil error")
+	}
+}
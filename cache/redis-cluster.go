@@ -2,25 +2,66 @@ package cache
 
 import (
 	"context"
-	"encoding/json"
-	"reflect"
+	"errors"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/binpossible49/go-libs/opentracing/jaeger"
-	"github.com/go-redis/redis"
+	"github.com/go-redis/redis/v7"
 	"github.com/opentracing/opentracing-go/ext"
 )
 
-func initRedisCluster(addrs []string) (*redis.ClusterClient, error) {
+// ErrClusterCursorNode is returned by GetKeysByPattern of a cluster when the master the
+// cursor points to left the cluster, after a failover for instance. Resuming on another
+// master would skip or repeat keys, the iteration has to start over.
+var ErrClusterCursorNode = errors.New("cache: master of the cursor is no longer in the cluster")
+
+func initRedisCluster(opts Options) (*redis.ClusterClient, error) {
+	tlsConfig, err := opts.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	hook := newTimeoutHook(opts)
 	clusterClient := redis.NewClusterClient(&redis.ClusterOptions{
-		Addrs: addrs,
+		Addrs:        opts.Addrs,
+		Password:     opts.password(),
+		OnConnect:    opts.onConnect(),
+		TLSConfig:    tlsConfig,
+		PoolSize:     opts.PoolSize,
+		MinIdleConns: opts.MinIdleConns,
+		DialTimeout:  opts.DialTimeout,
+		ReadTimeout:  opts.ReadTimeout,
+		WriteTimeout: opts.WriteTimeout,
+		// commands sent to a node directly, such as SCAN, are bounded and metered as well
+		NewClient: func(opt *redis.Options) *redis.Client {
+			client := redis.NewClient(opt)
+			client.AddHook(hook)
+			if opts.Metrics != nil {
+				client.AddHook(&metricsHook{metrics: opts.Metrics, node: true})
+			}
+			return client
+		},
 	})
-	_, err := clusterClient.Ping().Result()
-	return clusterClient, err
+	opts.addHooks(clusterClient)
+	if _, err := clusterClient.Ping().Result(); err != nil {
+		clusterClient.Close()
+		return nil, err
+	}
+	return clusterClient, nil
 }
 
 type clusterRedisHelper struct {
 	clusterClient *redis.ClusterClient
+	codec         *codec
+}
+
+func (h *clusterRedisHelper) redisClient() redis.UniversalClient {
+	return h.clusterClient
+}
+
+func (h *clusterRedisHelper) valueCodec() *codec {
+	return h.codec
 }
 
 func (h *clusterRedisHelper) Exists(ctx context.Context, key string) (err error) {
@@ -29,12 +70,12 @@ func (h *clusterRedisHelper) Exists(ctx context.Context, key string) (err error)
 		jaeger.Finish(span, err)
 	}()
 
-	indicator, err := h.clusterClient.Exists(key).Result()
+	indicator, err := h.clusterClient.WithContext(ctx).Exists(key).Result()
 	if err != nil {
 		return err
 	}
 	if indicator == 0 {
-		return redis.Nil
+		return &notFoundError{}
 	}
 	return nil
 }
@@ -45,11 +86,11 @@ func (h *clusterRedisHelper) Get(ctx context.Context, key string, value interfac
 		jaeger.Finish(span, err)
 	}()
 
-	data, err := h.clusterClient.Get(key).Result()
+	data, err := h.clusterClient.WithContext(ctx).Get(key).Bytes()
 	if err != nil {
-		return err
+		return wrapNotFound(err)
 	}
-	err = json.Unmarshal([]byte(data), &value)
+	err = h.codec.decode(data, value)
 	if err != nil {
 		return err
 	}
@@ -62,11 +103,11 @@ func (h *clusterRedisHelper) Set(ctx context.Context, key string, value interfac
 		jaeger.Finish(span, err)
 	}()
 
-	data, err := json.Marshal(value)
+	data, err := h.codec.encode(value)
 	if err != nil {
 		return err
 	}
-	_, err = h.clusterClient.Set(key, string(data), expiration).Result()
+	_, err = h.clusterClient.WithContext(ctx).Set(key, data, expiration).Result()
 	if err != nil {
 		return err
 	}
@@ -79,7 +120,7 @@ func (h *clusterRedisHelper) Del(ctx context.Context, key string) (err error) {
 		jaeger.Finish(span, err)
 	}()
 
-	_, err = h.clusterClient.Del(key).Result()
+	_, err = h.clusterClient.WithContext(ctx).Del(key).Result()
 	if err != nil {
 		return err
 	}
@@ -92,7 +133,7 @@ func (h *clusterRedisHelper) Expire(ctx context.Context, key string, expiration
 		jaeger.Finish(span, err)
 	}()
 
-	_, err = h.clusterClient.Expire(key, expiration).Result()
+	_, err = h.clusterClient.WithContext(ctx).Expire(key, expiration).Result()
 	if err != nil {
 		return err
 	}
@@ -106,35 +147,18 @@ func (h *clusterRedisHelper) GetInterface(ctx context.Context, key string, value
 		jaeger.Finish(span, err)
 	}()
 
-	data, err := h.clusterClient.Get(key).Result()
+	data, err := h.clusterClient.WithContext(ctx).Get(key).Bytes()
 	if err != nil {
+		err = wrapNotFound(err)
 		return nil, err
 	}
 
-	typeValue := reflect.TypeOf(value)
-	kind := typeValue.Kind()
-
-	var outData interface{}
-	switch kind {
-	case reflect.Ptr, reflect.Struct, reflect.Slice:
-		outData = reflect.New(typeValue).Interface()
-	default:
-		outData = reflect.Zero(typeValue).Interface()
-	}
-	err = json.Unmarshal([]byte(data), &outData)
+	outValue, err := decodeInterface(value, func(out interface{}) error {
+		return h.codec.decode(data, out)
+	})
 	if err != nil {
 		return nil, err
 	}
-
-	switch kind {
-	case reflect.Ptr, reflect.Struct, reflect.Slice:
-		return reflect.ValueOf(outData).Elem().Interface(), nil
-	}
-	var outValue interface{} = outData
-	if reflect.TypeOf(outData).ConvertibleTo(typeValue) {
-		outValueConverted := reflect.ValueOf(outData).Convert(typeValue)
-		outValue = outValueConverted.Interface()
-	}
 	return outValue, nil
 }
 
@@ -144,6 +168,16 @@ func (h *clusterRedisHelper) DelMulti(ctx context.Context, keys ...string) error
 	defer func() {
 		jaeger.Finish(span, err)
 	}()
+	if len(keys) == 0 {
+		return nil
+	}
+
+	// DEL across slots is rejected with CROSSSLOT, so send one DEL per slot
+	pipeline := h.clusterClient.WithContext(ctx).Pipeline()
+	for _, slotKeys := range groupKeysBySlot(keys) {
+		pipeline.Del(slotKeys...)
+	}
+	_, err = pipeline.Exec()
 	return err
 }
 
@@ -153,6 +187,118 @@ func (h *clusterRedisHelper) GetKeysByPattern(ctx context.Context, pattern strin
 	defer func() {
 		jaeger.Finish(span, err)
 	}()
+
+	masters, err := h.masterAddrs()
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(masters) == 0 {
+		return nil, 0, nil
+	}
+	nodeIndex, nodeCursor, err := decodeClusterCursor(cursor, masters)
+	if err != nil {
+		return nil, 0, err
+	}
+
 	var keys []string
-	return keys, 0, err
+	var nextNodeCursor uint64
+	err = h.clusterClient.ForEachMaster(func(client *redis.Client) error {
+		if client.Options().Addr != masters[nodeIndex] {
+			return nil
+		}
+		var scanErr error
+		keys, nextNodeCursor, scanErr = client.WithContext(ctx).Scan(nodeCursor, pattern, limit).Result()
+		return scanErr
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// move on to the next master once the current one is fully iterated
+	if nextNodeCursor == 0 {
+		nodeIndex++
+		if nodeIndex >= len(masters) {
+			return keys, 0, nil
+		}
+	}
+	next, err := encodeClusterCursor(masters[nodeIndex], nextNodeCursor)
+	if err != nil {
+		return nil, 0, err
+	}
+	return keys, next, nil
+}
+
+// masterAddrs returns the addresses of all masters in a stable order so that
+// a composite cursor moves on to the same next node between calls
+func (h *clusterRedisHelper) masterAddrs() ([]string, error) {
+	var mu sync.Mutex
+	var addrs []string
+	err := h.clusterClient.ForEachMaster(func(client *redis.Client) error {
+		mu.Lock()
+		addrs = append(addrs, client.Options().Addr)
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(addrs)
+	return addrs, nil
+}
+
+func (h *clusterRedisHelper) MGet(ctx context.Context, keys []string, values interface{}) (err error) {
+	span := jaeger.Start(ctx, ">helper.clusterRedisHelper/MGet", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	raws := make([][]byte, len(keys))
+	if len(keys) > 0 {
+		// MGET across slots is rejected with CROSSSLOT, so send one MGET per slot
+		positions := make(map[string][]int, len(keys))
+		for i, key := range keys {
+			positions[key] = append(positions[key], i)
+		}
+		pipeline := h.clusterClient.WithContext(ctx).Pipeline()
+		var cmds []*redis.SliceCmd
+		var slotKeys [][]string
+		for _, group := range groupKeysBySlot(keys) {
+			cmds = append(cmds, pipeline.MGet(group...))
+			slotKeys = append(slotKeys, group)
+		}
+		if _, err = pipeline.Exec(); err != nil {
+			return err
+		}
+		for i, cmd := range cmds {
+			for j, raw := range mgetRaws(cmd.Val()) {
+				for _, position := range positions[slotKeys[i][j]] {
+					raws[position] = raw
+				}
+			}
+		}
+	}
+	return fillMGetResult(keys, raws, values, h.codec.decode)
+}
+
+func (h *clusterRedisHelper) MSet(ctx context.Context, items ...Item) (err error) {
+	span := jaeger.Start(ctx, ">helper.clusterRedisHelper/MSet", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+	if len(items) == 0 {
+		return nil
+	}
+
+	pipeline := h.clusterClient.WithContext(ctx).Pipeline()
+	for _, item := range items {
+		var data []byte
+		data, err = h.codec.encode(item.Value)
+		if err != nil {
+			pipeline.Close()
+			return err
+		}
+		pipeline.Set(item.Key, data, item.Expiration)
+	}
+	_, err = pipeline.Exec()
+	return err
 }
@@ -2,26 +2,77 @@ package cache
 
 import (
 	"context"
-	"encoding/json"
-	"reflect"
 	"time"
 
 	"github.com/binpossible49/go-libs/opentracing/jaeger"
-	"github.com/go-redis/redis"
+	"github.com/go-redis/redis/v7"
 	"github.com/opentracing/opentracing-go/ext"
 )
 
 type redisHelper struct {
 	client *redis.Client
+	codec  *codec
 }
 
-func initRedis(addr string) (*redis.Client, error) {
+func initRedis(opts Options) (*redis.Client, error) {
+	tlsConfig, err := opts.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
 	client := redis.NewClient(&redis.Options{
-		Addr: addr,
-		DB:   0,
+		Addr:         opts.Addrs[0],
+		Password:     opts.password(),
+		DB:           opts.db(),
+		OnConnect:    opts.onConnect(),
+		TLSConfig:    tlsConfig,
+		PoolSize:     opts.PoolSize,
+		MinIdleConns: opts.MinIdleConns,
+		DialTimeout:  opts.DialTimeout,
+		ReadTimeout:  opts.ReadTimeout,
+		WriteTimeout: opts.WriteTimeout,
 	})
-	_, err := client.Ping().Result()
-	return client, err
+	opts.addHooks(client)
+	if _, err := client.Ping().Result(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+// initRedisSentinel creates a failover client, the helper is the same as a single node
+// because the client always talks to the current master
+func initRedisSentinel(opts Options) (*redis.Client, error) {
+	tlsConfig, err := opts.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	client := redis.NewFailoverClient(&redis.FailoverOptions{
+		MasterName:    opts.MasterName,
+		SentinelAddrs: opts.Addrs,
+		Password:      opts.password(),
+		DB:            opts.db(),
+		OnConnect:     opts.onConnect(),
+		TLSConfig:     tlsConfig,
+		PoolSize:      opts.PoolSize,
+		MinIdleConns:  opts.MinIdleConns,
+		DialTimeout:   opts.DialTimeout,
+		ReadTimeout:   opts.ReadTimeout,
+		WriteTimeout:  opts.WriteTimeout,
+	})
+	opts.addHooks(client)
+	if _, err := client.Ping().Result(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+func (h *redisHelper) redisClient() redis.UniversalClient {
+	return h.client
+}
+
+func (h *redisHelper) valueCodec() *codec {
+	return h.codec
 }
 
 func (h *redisHelper) Exists(ctx context.Context, key string) (err error) {
@@ -30,12 +81,12 @@ func (h *redisHelper) Exists(ctx context.Context, key string) (err error) {
 		jaeger.Finish(span, err)
 	}()
 
-	indicator, err := h.client.Exists(key).Result()
+	indicator, err := h.client.WithContext(ctx).Exists(key).Result()
 	if err != nil {
 		return err
 	}
 	if indicator == 0 {
-		return redis.Nil
+		return &notFoundError{}
 	}
 	return nil
 }
@@ -46,11 +97,11 @@ func (h *redisHelper) Get(ctx context.Context, key string, value interface{}) (e
 		jaeger.Finish(span, err)
 	}()
 
-	data, err := h.client.Get(key).Result()
+	data, err := h.client.WithContext(ctx).Get(key).Bytes()
 	if err != nil {
-		return err
+		return wrapNotFound(err)
 	}
-	err = json.Unmarshal([]byte(data), &value)
+	err = h.codec.decode(data, value)
 	if err != nil {
 		return err
 	}
@@ -63,12 +114,12 @@ func (h *redisHelper) Set(ctx context.Context, key string, value interface{}, ex
 		jaeger.Finish(span, err)
 	}()
 
-	data, err := json.Marshal(value)
+	data, err := h.codec.encode(value)
 	if err != nil {
 		return err
 	}
 
-	_, err = h.client.Set(key, string(data), expiration).Result()
+	_, err = h.client.WithContext(ctx).Set(key, data, expiration).Result()
 	if err != nil {
 		return err
 	}
@@ -81,7 +132,7 @@ func (h *redisHelper) Del(ctx context.Context, key string) (err error) {
 		jaeger.Finish(span, err)
 	}()
 
-	_, err = h.client.Del(key).Result()
+	_, err = h.client.WithContext(ctx).Del(key).Result()
 	if err != nil {
 		return err
 	}
@@ -94,7 +145,7 @@ func (h *redisHelper) Expire(ctx context.Context, key string, expiration time.Du
 		jaeger.Finish(span, err)
 	}()
 
-	_, err = h.client.Expire(key, expiration).Result()
+	_, err = h.client.WithContext(ctx).Expire(key, expiration).Result()
 	if err != nil {
 		return err
 	}
@@ -108,35 +159,18 @@ func (h *redisHelper) GetInterface(ctx context.Context, key string, value interf
 		jaeger.Finish(span, err)
 	}()
 
-	data, err := h.client.Get(key).Result()
+	data, err := h.client.WithContext(ctx).Get(key).Bytes()
 	if err != nil {
+		err = wrapNotFound(err)
 		return nil, err
 	}
 
-	typeValue := reflect.TypeOf(value)
-	kind := typeValue.Kind()
-
-	var outData interface{}
-	switch kind {
-	case reflect.Ptr, reflect.Struct, reflect.Slice:
-		outData = reflect.New(typeValue).Interface()
-	default:
-		outData = reflect.Zero(typeValue).Interface()
-	}
-	err = json.Unmarshal([]byte(data), &outData)
+	outValue, err := decodeInterface(value, func(out interface{}) error {
+		return h.codec.decode(data, out)
+	})
 	if err != nil {
 		return nil, err
 	}
-
-	switch kind {
-	case reflect.Ptr, reflect.Struct, reflect.Slice:
-		return reflect.ValueOf(outData).Elem().Interface(), nil
-	}
-	var outValue interface{} = outData
-	if reflect.TypeOf(outData).ConvertibleTo(typeValue) {
-		outValueConverted := reflect.ValueOf(outData).Convert(typeValue)
-		outValue = outValueConverted.Interface()
-	}
 	return outValue, nil
 }
 
@@ -146,17 +180,58 @@ func (h *redisHelper) DelMulti(ctx context.Context, keys ...string) error {
 	defer func() {
 		jaeger.Finish(span, err)
 	}()
-	pipeline := h.client.TxPipeline()
+	pipeline := h.client.WithContext(ctx).TxPipeline()
 	pipeline.Del(keys...)
 	_, err = pipeline.Exec()
 	return err
 }
 
-func (h *redisHelper) GetKeysByPattern(ctx context.Context, pattern string, cursor uint64, limit int64) ([]string, uint64, error) {
-	var err error
+func (h *redisHelper) GetKeysByPattern(ctx context.Context, pattern string, cursor uint64, limit int64) (keys []string, next uint64, err error) {
 	span := jaeger.Start(ctx, ">helper.redisHelper/GetKeysByPattern", ext.SpanKindRPCClient)
 	defer func() {
 		jaeger.Finish(span, err)
 	}()
-	return h.client.Scan(cursor, pattern, limit).Result()
+	keys, next, err = h.client.WithContext(ctx).Scan(cursor, pattern, limit).Result()
+	return keys, next, err
+}
+
+func (h *redisHelper) MGet(ctx context.Context, keys []string, values interface{}) (err error) {
+	span := jaeger.Start(ctx, ">helper.redisHelper/MGet", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	raws := make([][]byte, len(keys))
+	if len(keys) > 0 {
+		var replies []interface{}
+		replies, err = h.client.WithContext(ctx).MGet(keys...).Result()
+		if err != nil {
+			return err
+		}
+		raws = mgetRaws(replies)
+	}
+	return fillMGetResult(keys, raws, values, h.codec.decode)
+}
+
+func (h *redisHelper) MSet(ctx context.Context, items ...Item) (err error) {
+	span := jaeger.Start(ctx, ">helper.redisHelper/MSet", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+	if len(items) == 0 {
+		return nil
+	}
+
+	pipeline := h.client.WithContext(ctx).TxPipeline()
+	for _, item := range items {
+		var data []byte
+		data, err = h.codec.encode(item.Value)
+		if err != nil {
+			pipeline.Close()
+			return err
+		}
+		pipeline.Set(item.Key, data, item.Expiration)
+	}
+	_, err = pipeline.Exec()
+	return err
 }
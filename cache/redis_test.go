@@ -0,0 +1,22 @@
+package cache
+
+import (
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// newTestRedisHelper starts an in-process redis server for the test and returns a helper on it
+func newTestRedisHelper(t *testing.T) (*miniredis.Miniredis, CacheHelper) {
+	t.Helper()
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+	helper, err := NewCacheHelperWithOptions(Options{Addrs: []string{server.Addr()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server, helper
+}
@@ -0,0 +1,251 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+
+	"github.com/binpossible49/go-libs/opentracing/jaeger"
+	"github.com/go-redis/redis/v7"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+// builtinScripts are the scripts of the cache package, every registry preloads them
+var builtinScripts = map[string]*redis.Script{}
+
+// newBuiltinScript declares a script of the cache package under name
+func newBuiltinScript(name, source string) *redis.Script {
+	script := redis.NewScript(source)
+	builtinScripts["cache."+name] = script
+	return script
+}
+
+// ScriptRegistry runs named Lua scripts with EVALSHA, falling back to EVAL when a node
+// does not know a script yet
+type ScriptRegistry interface {
+	// Register adds a script, registering a name again replaces its script
+	Register(name, source string)
+	// Load preloads every script on every node so that EVALSHA does not miss,
+	// the scripts of the cache package are included
+	Load(ctx context.Context) error
+	Run(ctx context.Context, name string, keys []string, args ...interface{}) *ScriptResult
+}
+
+type scriptRegistry struct {
+	client redis.UniversalClient
+
+	mu      sync.RWMutex
+	scripts map[string]*redis.Script
+}
+
+// NewScriptRegistry creates an instance on the redis behind helper
+func NewScriptRegistry(helper CacheHelper) (ScriptRegistry, error) {
+	client, err := redisClientOf(helper)
+	if err != nil {
+		return nil, err
+	}
+	r := &scriptRegistry{
+		client:  client,
+		scripts: make(map[string]*redis.Script, len(builtinScripts)),
+	}
+	for name, script := range builtinScripts {
+		r.scripts[name] = script
+	}
+	return r, nil
+}
+
+func (r *scriptRegistry) Register(name, source string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.scripts[name] = redis.NewScript(source)
+}
+
+func (r *scriptRegistry) script(name string) (*redis.Script, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	script, ok := r.scripts[name]
+	return script, ok
+}
+
+func (r *scriptRegistry) Load(ctx context.Context) (err error) {
+	span := jaeger.Start(ctx, ">helper.scriptRegistry/Load", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	r.mu.RLock()
+	scripts := make([]*redis.Script, 0, len(r.scripts))
+	for _, script := range r.scripts {
+		scripts = append(scripts, script)
+	}
+	r.mu.RUnlock()
+
+	load := func(client redis.UniversalClient) error {
+		for _, script := range scripts {
+			if err := script.Load(withContext(ctx, client)).Err(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if cluster, ok := r.client.(*redis.ClusterClient); ok {
+		// SCRIPT LOAD goes to a single node, replicas are included as they may serve reads
+		return cluster.ForEachNode(func(client *redis.Client) error {
+			return load(client)
+		})
+	}
+	return load(r.client)
+}
+
+func (r *scriptRegistry) Run(ctx context.Context, name string, keys []string, args ...interface{}) *ScriptResult {
+	var err error
+	span := jaeger.Start(ctx, ">helper.scriptRegistry/Run", ext.SpanKindRPCClient, opentracing.Tag{Key: "script.name", Value: name})
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	script, ok := r.script(name)
+	if !ok {
+		err = fmt.Errorf("cache: unknown script %q", name)
+		return &ScriptResult{name: name, err: err}
+	}
+	val, err := script.Run(withContext(ctx, r.client), keys, args...).Result()
+	return &ScriptResult{name: name, val: val, err: err}
+}
+
+// ScriptResult is the reply of a script, a nil reply (Lua false or nil) matches ErrNotFound
+type ScriptResult struct {
+	name string
+	val  interface{}
+	err  error
+}
+
+// Err returns the error of the script
+func (r *ScriptResult) Err() error {
+	return wrapNotFound(r.err)
+}
+
+// Val returns the raw reply: int64, string, []interface{} or nil
+func (r *ScriptResult) Val() interface{} {
+	return r.val
+}
+
+// Int64 returns an integer reply
+func (r *ScriptResult) Int64() (int64, error) {
+	var value int64
+	err := r.Decode(&value)
+	return value, err
+}
+
+// Text returns a string reply
+func (r *ScriptResult) Text() (string, error) {
+	var value string
+	err := r.Decode(&value)
+	return value, err
+}
+
+// Bool returns an integer reply as a bool, Lua true is returned by redis as 1
+func (r *ScriptResult) Bool() (bool, error) {
+	var value bool
+	err := r.Decode(&value)
+	return value, err
+}
+
+// Int64Slice returns an array reply of integers
+func (r *ScriptResult) Int64Slice() ([]int64, error) {
+	var values []int64
+	err := r.Decode(&values)
+	return values, err
+}
+
+// StringSlice returns an array reply of strings
+func (r *ScriptResult) StringSlice() ([]string, error) {
+	var values []string
+	err := r.Decode(&values)
+	return values, err
+}
+
+// Decode converts the reply into value, a pointer to a string, a number, a bool,
+// or a slice of them for array replies
+func (r *ScriptResult) Decode(value interface{}) error {
+	if err := r.Err(); err != nil {
+		return err
+	}
+	target := reflect.ValueOf(value)
+	if target.Kind() != reflect.Ptr || target.IsNil() {
+		return ErrInvalidTarget
+	}
+	return decodeScriptReply(r.val, target.Elem())
+}
+
+func decodeScriptReply(reply interface{}, target reflect.Value) error {
+	if reply == nil {
+		return nil
+	}
+	if target.Kind() == reflect.Interface {
+		target.Set(reflect.ValueOf(reply))
+		return nil
+	}
+	if text, ok := reply.(string); ok && target.Type() == reflect.TypeOf([]byte(nil)) {
+		target.SetBytes([]byte(text))
+		return nil
+	}
+	if target.Kind() == reflect.Slice {
+		elements, ok := reply.([]interface{})
+		if !ok {
+			return fmt.Errorf("cache: cannot decode script reply %T into %s", reply, target.Type())
+		}
+		slice := reflect.MakeSlice(target.Type(), len(elements), len(elements))
+		for i, element := range elements {
+			if element == nil {
+				continue
+			}
+			if err := decodeScriptReply(element, slice.Index(i)); err != nil {
+				return err
+			}
+		}
+		target.Set(slice)
+		return nil
+	}
+
+	// scalars are strings or integers, both are converted through their text
+	var text string
+	switch reply := reply.(type) {
+	case string:
+		text = reply
+	case int64:
+		text = strconv.FormatInt(reply, 10)
+	default:
+		return fmt.Errorf("cache: cannot decode script reply %T into %s", reply, target.Type())
+	}
+	switch target.Kind() {
+	case reflect.String:
+		target.SetString(text)
+	case reflect.Bool:
+		target.SetBool(text != "0" && text != "")
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return err
+		}
+		target.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(text, 10, 64)
+		if err != nil {
+			return err
+		}
+		target.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return err
+		}
+		target.SetFloat(f)
+	default:
+		return fmt.Errorf("cache: cannot decode script reply into %s", target.Type())
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v7"
+)
+
+func TestBuiltinScripts(t *testing.T) {
+	for _, name := range []string{"cache.lock.acquire", "cache.rateLimit.gcra", "cache.idempotency.claim"} {
+		if _, ok := builtinScripts[name]; !ok {
+			t.Errorf("builtinScripts[%q] is missing", name)
+		}
+	}
+}
+
+func TestScriptResultDecode(t *testing.T) {
+	result := &ScriptResult{name: "s", val: []interface{}{int64(1), "2", nil}}
+	numbers, err := result.Int64Slice()
+	if err != nil || !reflect.DeepEqual(numbers, []int64{1, 2, 0}) {
+		t.Errorf("Int64Slice() = %v, %v", numbers, err)
+	}
+	texts, err := result.StringSlice()
+	if err != nil || !reflect.DeepEqual(texts, []string{"1", "2", ""}) {
+		t.Errorf("StringSlice() = %v, %v", texts, err)
+	}
+
+	if ok, err := (&ScriptResult{val: int64(1)}).Bool(); err != nil || !ok {
+		t.Errorf("Bool() = %v, %v", ok, err)
+	}
+	var f float64
+	if err := (&ScriptResult{val: "1.5"}).Decode(&f); err != nil || f != 1.5 {
+		t.Errorf("Decode(float64) = %v, %v", f, err)
+	}
+	var data []byte
+	if err := (&ScriptResult{val: "abc"}).Decode(&data); err != nil || string(data) != "abc" {
+		t.Errorf("Decode([]byte) = %q, %v", data, err)
+	}
+	if _, err := (&ScriptResult{val: "abc"}).Int64(); err == nil {
+		t.Error("Int64() of a non numeric reply should fail")
+	}
+	if err := (&ScriptResult{val: int64(1)}).Decode(f); err != ErrInvalidTarget {
+		t.Errorf("Decode(non pointer) = %v, want ErrInvalidTarget", err)
+	}
+	if _, err := (&ScriptResult{name: "s", err: redis.Nil}).Text(); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Text() of a nil reply = %v, want ErrNotFound", err)
+	}
+}
+
+func TestScriptRegistry(t *testing.T) {
+	ctx := context.Background()
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	metrics := &recordingMetrics{}
+	helper, err := NewCacheHelperWithOptions(Options{Addrs: []string{server.Addr()}, Metrics: metrics})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, _ := redisClientOf(helper)
+	registry, err := NewScriptRegistry(helper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const source = `return redis.call("INCRBY", KEYS[1], ARGV[1])`
+	registry.Register("incr", source)
+
+	if err := registry.Load(ctx); err != nil {
+		t.Fatal(err)
+	}
+	shas := []string{redis.NewScript(source).Hash(), builtinScripts["cache.lock.acquire"].Hash()}
+	if loaded, err := client.ScriptExists(shas...).Result(); err != nil || !reflect.DeepEqual(loaded, []bool{true, true}) {
+		t.Errorf("SCRIPT EXISTS after Load() = %v, %v, want the registered and builtin scripts", loaded, err)
+	}
+
+	metrics.reset()
+	if n, err := registry.Run(ctx, "incr", []string{"counter"}, 2).Int64(); err != nil || n != 2 {
+		t.Fatalf("Run() = %d, %v, want 2", n, err)
+	}
+	if commands := commandNames(metrics); !reflect.DeepEqual(commands, []string{"evalsha"}) {
+		t.Errorf("commands of Run() = %v, want EVALSHA only", commands)
+	}
+
+	// a node that lost its scripts gets the source
+	if err := client.ScriptFlush().Err(); err != nil {
+		t.Fatal(err)
+	}
+	metrics.reset()
+	if n, err := registry.Run(ctx, "incr", []string{"counter"}, 3).Int64(); err != nil || n != 5 {
+		t.Fatalf("Run() after SCRIPT FLUSH = %d, %v, want 5", n, err)
+	}
+	if commands := commandNames(metrics); !reflect.DeepEqual(commands, []string{"evalsha", "eval"}) {
+		t.Errorf("commands of Run() after SCRIPT FLUSH = %v, want EVALSHA then EVAL", commands)
+	}
+
+	result := registry.Run(ctx, "missing", nil)
+	if err := result.Err(); err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("Run() of an unknown script = %v, want an error other than ErrNotFound", err)
+	}
+	if _, err := result.Int64(); err == nil {
+		t.Error("Int64() of an unknown script should fail")
+	}
+}
+
+func commandNames(metrics *recordingMetrics) []string {
+	commands, _, _ := metrics.reset()
+	names := make([]string, len(commands))
+	for i, command := range commands {
+		names[i] = command.command
+	}
+	return names
+}
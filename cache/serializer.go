@@ -0,0 +1,227 @@
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/vmihailenco/msgpack/v4"
+)
+
+// Serializer encodes cached values
+type Serializer interface {
+	// ID identifies the format in the header of stored values, it must be lower than 32
+	// and 0 to 7 are reserved for the serializers of this package
+	ID() uint8
+	Marshal(value interface{}) ([]byte, error)
+	Unmarshal(data []byte, value interface{}) error
+}
+
+// Compression is the algorithm compressing large cached values
+type Compression uint8
+
+const (
+	// CompressionNone disables compression
+	CompressionNone Compression = iota
+	// CompressionGzip compresses with gzip, smaller but slower than snappy
+	CompressionGzip
+	// CompressionSnappy compresses with snappy
+	CompressionSnappy
+)
+
+const (
+	defaultCompressionThreshold = 1024
+
+	// Values with a header start with a byte of which the high bit is set, it never starts
+	// a JSON document so values written before serializers existed are still readable
+	headerFlag            = 0x80
+	headerSerializerShift = 2
+	headerCompressionMask = 0x03
+	maxSerializerID       = 0x1f
+)
+
+var (
+	// JSONSerializer encodes with encoding/json, it is the default and writes values without header
+	// unless they are compressed
+	JSONSerializer Serializer = jsonSerializer{}
+	// MsgpackSerializer encodes with MessagePack
+	MsgpackSerializer Serializer = msgpackSerializer{}
+	// ProtobufSerializer encodes protobuf messages, values must implement proto.Message
+	ProtobufSerializer Serializer = protobufSerializer{}
+	// GobSerializer encodes with encoding/gob
+	GobSerializer Serializer = gobSerializer{}
+)
+
+type jsonSerializer struct{}
+
+func (jsonSerializer) ID() uint8 { return 0 }
+
+func (jsonSerializer) Marshal(value interface{}) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+func (jsonSerializer) Unmarshal(data []byte, value interface{}) error {
+	return json.Unmarshal(data, &value)
+}
+
+type msgpackSerializer struct{}
+
+func (msgpackSerializer) ID() uint8 { return 1 }
+
+func (msgpackSerializer) Marshal(value interface{}) ([]byte, error) {
+	return msgpack.Marshal(value)
+}
+
+func (msgpackSerializer) Unmarshal(data []byte, value interface{}) error {
+	return msgpack.Unmarshal(data, value)
+}
+
+type protobufSerializer struct{}
+
+func (protobufSerializer) ID() uint8 { return 2 }
+
+func (protobufSerializer) Marshal(value interface{}) ([]byte, error) {
+	message, ok := value.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("cache: %T is not a proto.Message", value)
+	}
+	return proto.Marshal(message)
+}
+
+func (protobufSerializer) Unmarshal(data []byte, value interface{}) error {
+	message, ok := value.(proto.Message)
+	if !ok {
+		return fmt.Errorf("cache: %T is not a proto.Message", value)
+	}
+	return proto.Unmarshal(data, message)
+}
+
+type gobSerializer struct{}
+
+func (gobSerializer) ID() uint8 { return 3 }
+
+func (gobSerializer) Marshal(value interface{}) ([]byte, error) {
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(value); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func (gobSerializer) Unmarshal(data []byte, value interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(value)
+}
+
+// codec turns values into what is stored in redis, reading every known format
+// whatever the configured one is
+type codec struct {
+	serializer  Serializer
+	serializers map[uint8]Serializer
+	compression Compression
+	threshold   int
+}
+
+func newCodec(serializer Serializer, compression Compression, threshold int) (*codec, error) {
+	if serializer == nil {
+		serializer = JSONSerializer
+	}
+	if serializer.ID() > maxSerializerID {
+		return nil, fmt.Errorf("cache: serializer ID %d is greater than %d", serializer.ID(), maxSerializerID)
+	}
+	if compression > CompressionSnappy {
+		return nil, fmt.Errorf("cache: unknown compression %d", compression)
+	}
+	if threshold <= 0 {
+		threshold = defaultCompressionThreshold
+	}
+	c := &codec{
+		serializer:  serializer,
+		serializers: make(map[uint8]Serializer),
+		compression: compression,
+		threshold:   threshold,
+	}
+	for _, s := range []Serializer{JSONSerializer, MsgpackSerializer, ProtobufSerializer, GobSerializer, serializer} {
+		c.serializers[s.ID()] = s
+	}
+	return c, nil
+}
+
+// defaultCodec writes plain JSON like the helpers always did
+var defaultCodec, _ = newCodec(nil, CompressionNone, 0)
+
+func (c *codec) encode(value interface{}) ([]byte, error) {
+	data, err := c.serializer.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	compression := CompressionNone
+	if c.compression != CompressionNone && len(data) >= c.threshold {
+		if data, err = compress(c.compression, data); err != nil {
+			return nil, err
+		}
+		compression = c.compression
+	}
+	if c.serializer.ID() == JSONSerializer.ID() && compression == CompressionNone {
+		return data, nil
+	}
+
+	header := byte(headerFlag | c.serializer.ID()<<headerSerializerShift | uint8(compression))
+	return append([]byte{header}, data...), nil
+}
+
+func (c *codec) decode(data []byte, value interface{}) error {
+	if len(data) == 0 || data[0]&headerFlag == 0 {
+		return JSONSerializer.Unmarshal(data, value)
+	}
+
+	header := data[0]
+	serializer, ok := c.serializers[(header&^headerFlag)>>headerSerializerShift]
+	if !ok {
+		return fmt.Errorf("cache: unknown serializer in header %#x", header)
+	}
+	data, err := decompress(Compression(header&headerCompressionMask), data[1:])
+	if err != nil {
+		return err
+	}
+	return serializer.Unmarshal(data, value)
+}
+
+func compress(compression Compression, data []byte) ([]byte, error) {
+	switch compression {
+	case CompressionGzip:
+		var buffer bytes.Buffer
+		writer := gzip.NewWriter(&buffer)
+		if _, err := writer.Write(data); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+		return buffer.Bytes(), nil
+	case CompressionSnappy:
+		return snappy.Encode(nil, data), nil
+	}
+	return data, nil
+}
+
+func decompress(compression Compression, data []byte) ([]byte, error) {
+	switch compression {
+	case CompressionNone:
+		return data, nil
+	case CompressionGzip:
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return ioutil.ReadAll(reader)
+	case CompressionSnappy:
+		return snappy.Decode(nil, data)
+	}
+	return nil, fmt.Errorf("cache: unknown compression %d", compression)
+}
@@ -0,0 +1,51 @@
+package cache
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+type codecValue struct {
+	Name  string
+	Items []string
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	value := codecValue{Name: "test", Items: []string{strings.Repeat("x", 2048), "y"}}
+	for _, serializer := range []Serializer{JSONSerializer, MsgpackSerializer, GobSerializer} {
+		for _, compression := range []Compression{CompressionNone, CompressionGzip, CompressionSnappy} {
+			c, err := newCodec(serializer, compression, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := c.encode(value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got codecValue
+			if err := defaultCodec.decode(data, &got); err != nil {
+				t.Fatalf("serializer %d compression %d: %v", serializer.ID(), compression, err)
+			}
+			if got.Name != value.Name || len(got.Items) != 2 || got.Items[0] != value.Items[0] {
+				t.Errorf("serializer %d compression %d: got %+v", serializer.ID(), compression, got)
+			}
+		}
+	}
+}
+
+func TestCodecLegacyJSON(t *testing.T) {
+	data, err := defaultCodec.encode(codecValue{Name: "legacy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, []byte(`{"Name":"legacy"`)) {
+		t.Errorf("default codec must write plain JSON, got %q", data)
+	}
+
+	c, _ := newCodec(MsgpackSerializer, CompressionSnappy, 1)
+	var got codecValue
+	if err := c.decode([]byte(`{"Name":"legacy"}`), &got); err != nil || got.Name != "legacy" {
+		t.Errorf("decode() = %+v, %v", got, err)
+	}
+}
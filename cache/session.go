@@ -0,0 +1,369 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/binpossible49/go-libs/opentracing/jaeger"
+	"github.com/go-redis/redis/v7"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultSessionKeyPrefix   = "session:"
+	defaultSessionIdleTimeout = 30 * time.Minute
+	defaultSessionMaxLifetime = 24 * time.Hour
+	sessionIDSize             = 32
+)
+
+// indexSessionScript adds a session to the index of its user and returns the oldest
+// sessions beyond the limit, concurrent creations see each other's sessions
+var indexSessionScript = newBuiltinScript("session.index", `
+redis.call("ZADD", KEYS[1], ARGV[2], ARGV[1])
+redis.call("PEXPIRE", KEYS[1], ARGV[3])
+local limit = tonumber(ARGV[4])
+if limit <= 0 then
+	return {}
+end
+local excess = redis.call("ZCARD", KEYS[1]) - limit
+if excess <= 0 then
+	return {}
+end
+return redis.call("ZRANGE", KEYS[1], 0, excess - 1)`)
+
+// Session is a server-side session, Data is free for the application
+type Session struct {
+	ID         string            `json:"id"`
+	UserID     string            `json:"uid"`
+	Data       map[string]string `json:"data,omitempty"`
+	CreatedAt  time.Time         `json:"c"`
+	LastSeenAt time.Time         `json:"s"`
+	// ExpiresAt moves forward on every touch, up to CreatedAt plus the max lifetime
+	ExpiresAt time.Time `json:"e"`
+}
+
+// SessionStore keeps sessions in the cache, sessions expire when idle for longer than
+// the idle timeout and at the latest after the max lifetime. Expired, revoked and unknown
+// sessions are not found: errors.Is(err, ErrNotFound).
+type SessionStore interface {
+	// Create starts a session of userID, the oldest sessions of the user are revoked
+	// beyond the per user limit
+	Create(ctx context.Context, userID string, data map[string]string) (*Session, error)
+	// Load reads a session without extending it
+	Load(ctx context.Context, id string) (*Session, error)
+	// Touch reads a session and slides its expiration
+	Touch(ctx context.Context, id string) (*Session, error)
+	// Save stores the Data of session, its expiration is left as is. A session revoked
+	// or expired meanwhile is not stored again.
+	Save(ctx context.Context, session *Session) error
+	Revoke(ctx context.Context, id string) error
+	// RevokeAll revokes every session of userID
+	RevokeAll(ctx context.Context, userID string) error
+	// List returns the sessions of userID, oldest first
+	List(ctx context.Context, userID string) ([]*Session, error)
+}
+
+// SessionOptions represents options of SessionStore
+type SessionOptions struct {
+	// KeyPrefix is prepended to session and user index keys
+	KeyPrefix string
+	// IdleTimeout is how long a session lives without being touched, 30m by default
+	IdleTimeout time.Duration
+	// MaxLifetime is how long a session lives at most, 24h by default
+	MaxLifetime time.Duration
+	// MaxPerUser limits the concurrent sessions of a user, 0 is unlimited
+	MaxPerUser int
+}
+
+type sessionStore struct {
+	helper CacheHelper
+	client redis.UniversalClient
+	codec  *codec
+	opts   SessionOptions
+	now    func() time.Time
+}
+
+// NewSessionStore creates an instance on the redis behind helper.
+// Only helpers created by NewCacheHelper are supported, decorators would be bypassed.
+func NewSessionStore(helper CacheHelper, opts SessionOptions) (SessionStore, error) {
+	h, ok := helper.(redisClientHelper)
+	if !ok {
+		return nil, ErrUnsupportedHelper
+	}
+	if opts.KeyPrefix == "" {
+		opts.KeyPrefix = defaultSessionKeyPrefix
+	}
+	if opts.IdleTimeout <= 0 {
+		opts.IdleTimeout = defaultSessionIdleTimeout
+	}
+	if opts.MaxLifetime <= 0 {
+		opts.MaxLifetime = defaultSessionMaxLifetime
+	}
+	return &sessionStore{
+		helper: helper,
+		client: h.redisClient(),
+		codec:  h.valueCodec(),
+		opts:   opts,
+		now:    time.Now,
+	}, nil
+}
+
+func (s *sessionStore) sessionKey(id string) string {
+	return s.opts.KeyPrefix + id
+}
+
+func (s *sessionStore) userKey(userID string) string {
+	return s.opts.KeyPrefix + "user:" + userID
+}
+
+func (s *sessionStore) Create(ctx context.Context, userID string, data map[string]string) (session *Session, err error) {
+	span := jaeger.Start(ctx, ">helper.sessionStore/Create", ext.SpanKindRPCClient, opentracing.Tag{Key: "session.user", Value: userID})
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	id, err := newSessionID()
+	if err != nil {
+		return nil, err
+	}
+	if s.opts.MaxPerUser > 0 {
+		// expired sessions do not count against the limit
+		if _, err := s.activeIDs(ctx, userID); err != nil {
+			return nil, err
+		}
+	}
+	now := s.now()
+	session = &Session{
+		ID:         id,
+		UserID:     userID,
+		Data:       data,
+		CreatedAt:  now,
+		LastSeenAt: now,
+	}
+	ttl := s.slide(session, now)
+	if err := s.helper.Set(ctx, s.sessionKey(id), session, ttl); err != nil {
+		return nil, err
+	}
+
+	reply, err := indexSessionScript.Run(withContext(ctx, s.client), []string{s.userKey(userID)},
+		id, now.UnixNano()/int64(time.Millisecond), s.opts.MaxLifetime.Milliseconds(), s.opts.MaxPerUser).Result()
+	if err == nil {
+		members, _ := reply.([]interface{})
+		excess := make([]string, 0, len(members))
+		for _, member := range members {
+			if oldID, ok := member.(string); ok {
+				excess = append(excess, oldID)
+			}
+		}
+		// an excess left by a failure is revoked by the next creation
+		err = s.revoke(ctx, userID, excess...)
+	}
+	if err != nil {
+		// the limit could not be enforced, the session is not handed out
+		if revokeErr := s.revoke(ctx, userID, id); revokeErr != nil {
+			zap.S().Warnw("Failed to revoke a session beyond the limit", "user", userID, zap.Error(revokeErr))
+		}
+		return nil, err
+	}
+	return session, nil
+}
+
+// activeIDs returns the sessions of userID still in the cache, oldest first,
+// expired ones are removed from the index
+func (s *sessionStore) activeIDs(ctx context.Context, userID string) ([]string, error) {
+	client := withContext(ctx, s.client)
+	userKey := s.userKey(userID)
+	ids, err := client.ZRange(userKey, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	active := make([]string, 0, len(ids))
+	expired := make([]interface{}, 0)
+	for _, id := range ids {
+		// sessions may live on other cluster slots, EXISTS is sent per key
+		found, err := client.Exists(s.sessionKey(id)).Result()
+		if err != nil {
+			return nil, err
+		}
+		if found == 0 {
+			expired = append(expired, id)
+			continue
+		}
+		active = append(active, id)
+	}
+	if len(expired) > 0 {
+		if err := client.ZRem(userKey, expired...).Err(); err != nil {
+			return nil, err
+		}
+	}
+	return active, nil
+}
+
+func (s *sessionStore) Load(ctx context.Context, id string) (session *Session, err error) {
+	span := jaeger.Start(ctx, ">helper.sessionStore/Load", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	return s.load(ctx, id)
+}
+
+func (s *sessionStore) load(ctx context.Context, id string) (*Session, error) {
+	session := &Session{}
+	if err := s.helper.Get(ctx, s.sessionKey(id), session); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+func (s *sessionStore) Touch(ctx context.Context, id string) (session *Session, err error) {
+	span := jaeger.Start(ctx, ">helper.sessionStore/Touch", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	session, err = s.load(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	now := s.now()
+	session.LastSeenAt = now
+	ttl := s.slide(session, now)
+	if ttl <= 0 {
+		return nil, &notFoundError{}
+	}
+	if err := s.update(ctx, session, ttl); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// update stores session for ttl unless it is no longer in the cache, so that a session
+// revoked meanwhile is not stored again
+func (s *sessionStore) update(ctx context.Context, session *Session, ttl time.Duration) error {
+	data, err := s.codec.encode(session)
+	if err != nil {
+		return err
+	}
+	stored, err := withContext(ctx, s.client).SetXX(s.sessionKey(session.ID), data, ttl).Result()
+	if err != nil {
+		return err
+	}
+	if !stored {
+		return &notFoundError{}
+	}
+	return nil
+}
+
+// slide moves the expiration of session to now plus the idle timeout, capped by the
+// max lifetime, and returns the TTL left
+func (s *sessionStore) slide(session *Session, now time.Time) time.Duration {
+	session.ExpiresAt = now.Add(s.opts.IdleTimeout)
+	if deadline := session.CreatedAt.Add(s.opts.MaxLifetime); session.ExpiresAt.After(deadline) {
+		session.ExpiresAt = deadline
+	}
+	return session.ExpiresAt.Sub(now)
+}
+
+func (s *sessionStore) Save(ctx context.Context, session *Session) (err error) {
+	span := jaeger.Start(ctx, ">helper.sessionStore/Save", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	ttl := session.ExpiresAt.Sub(s.now())
+	if ttl <= 0 {
+		return &notFoundError{}
+	}
+	return s.update(ctx, session, ttl)
+}
+
+func (s *sessionStore) Revoke(ctx context.Context, id string) (err error) {
+	span := jaeger.Start(ctx, ">helper.sessionStore/Revoke", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	session, err := s.load(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return s.revoke(ctx, session.UserID, id)
+}
+
+func (s *sessionStore) RevokeAll(ctx context.Context, userID string) (err error) {
+	span := jaeger.Start(ctx, ">helper.sessionStore/RevokeAll", ext.SpanKindRPCClient, opentracing.Tag{Key: "session.user", Value: userID})
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	ids, err := withContext(ctx, s.client).ZRange(s.userKey(userID), 0, -1).Result()
+	if err != nil {
+		return err
+	}
+	if err := s.revoke(ctx, userID, ids...); err != nil {
+		return err
+	}
+	return withContext(ctx, s.client).Del(s.userKey(userID)).Err()
+}
+
+// revoke deletes sessions of userID and removes them from its index
+func (s *sessionStore) revoke(ctx context.Context, userID string, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(ids))
+	members := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		keys = append(keys, s.sessionKey(id))
+		members = append(members, id)
+	}
+	if err := s.helper.DelMulti(ctx, keys...); err != nil {
+		return err
+	}
+	return withContext(ctx, s.client).ZRem(s.userKey(userID), members...).Err()
+}
+
+func (s *sessionStore) List(ctx context.Context, userID string) (sessions []*Session, err error) {
+	span := jaeger.Start(ctx, ">helper.sessionStore/List", ext.SpanKindRPCClient, opentracing.Tag{Key: "session.user", Value: userID})
+	defer func() {
+		span.SetTag("session.count", len(sessions))
+		jaeger.Finish(span, err)
+	}()
+
+	ids, err := s.activeIDs(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	sessions = make([]*Session, 0, len(ids))
+	for _, id := range ids {
+		session, err := s.load(ctx, id)
+		if errors.Is(err, ErrNotFound) {
+			// expired since activeIDs
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
+
+// newSessionID returns an opaque URL-safe token of 256 random bits
+func newSessionID() (string, error) {
+	b := make([]byte, sessionIDSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
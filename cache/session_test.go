@@ -0,0 +1,184 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestSessionSlide(t *testing.T) {
+	s := &sessionStore{opts: SessionOptions{IdleTimeout: 30 * time.Minute, MaxLifetime: time.Hour}}
+	created := time.Now()
+	session := &Session{CreatedAt: created}
+
+	if ttl := s.slide(session, created.Add(10*time.Minute)); ttl != 30*time.Minute {
+		t.Errorf("slide() = %s, want the idle timeout", ttl)
+	}
+	if ttl := s.slide(session, created.Add(50*time.Minute)); ttl != 10*time.Minute || !session.ExpiresAt.Equal(created.Add(time.Hour)) {
+		t.Errorf("slide() = %s, expires at %s, want capped by the max lifetime", ttl, session.ExpiresAt)
+	}
+	if ttl := s.slide(session, created.Add(2*time.Hour)); ttl > 0 {
+		t.Errorf("slide() = %s past the max lifetime, want <= 0", ttl)
+	}
+}
+
+func TestNewSessionID(t *testing.T) {
+	a, err := newSessionID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := newSessionID()
+	if len(a) != 43 || a == b {
+		t.Errorf("newSessionID() = %q, %q, want distinct 43 character tokens", a, b)
+	}
+}
+
+func newTestSessionStore(t *testing.T, opts SessionOptions) (*sessionStore, *miniredis.Miniredis) {
+	t.Helper()
+	server, helper := newTestRedisHelper(t)
+	store, err := NewSessionStore(helper, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store.(*sessionStore), server
+}
+
+func TestSessionStore(t *testing.T) {
+	ctx := context.Background()
+	s, server := newTestSessionStore(t, SessionOptions{IdleTimeout: time.Minute, MaxLifetime: time.Hour})
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	session, err := s.Create(ctx, "42", map[string]string{"lang": "en"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl := server.TTL("session:" + session.ID); ttl != time.Minute {
+		t.Errorf("TTL after Create() = %s, want the idle timeout", ttl)
+	}
+	loaded, err := s.Load(ctx, session.ID)
+	if err != nil || loaded.UserID != "42" || loaded.Data["lang"] != "en" {
+		t.Fatalf("Load() = %+v, %v", loaded, err)
+	}
+
+	now = now.Add(30 * time.Second)
+	touched, err := s.Touch(ctx, session.ID)
+	if err != nil || !touched.ExpiresAt.Equal(now.Add(time.Minute)) {
+		t.Fatalf("Touch() = %+v, %v, want the expiration moved", touched, err)
+	}
+	touched.Data["lang"] = "fr"
+	if err := s.Save(ctx, touched); err != nil {
+		t.Fatal(err)
+	}
+	if loaded, _ := s.Load(ctx, session.ID); loaded.Data["lang"] != "fr" {
+		t.Errorf("Load() after Save() = %v", loaded.Data)
+	}
+
+	other, _ := s.Create(ctx, "42", nil)
+	sessions, err := s.List(ctx, "42")
+	if err != nil || len(sessions) != 2 || sessions[0].ID != session.ID || sessions[1].ID != other.ID {
+		t.Fatalf("List() = %v, %v, want both sessions oldest first", sessions, err)
+	}
+
+	if err := s.Revoke(ctx, session.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Load(ctx, session.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Load() after Revoke() = %v, want ErrNotFound", err)
+	}
+	// a revoked session is not stored again
+	if _, err := s.Touch(ctx, session.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Touch() after Revoke() = %v, want ErrNotFound", err)
+	}
+	if err := s.Save(ctx, touched); !errors.Is(err, ErrNotFound) || server.Exists("session:"+session.ID) {
+		t.Errorf("Save() after Revoke() = %v, want ErrNotFound", err)
+	}
+	if err := s.Revoke(ctx, session.ID); err != nil {
+		t.Errorf("Revoke() of a revoked session = %v", err)
+	}
+
+	if err := s.RevokeAll(ctx, "42"); err != nil {
+		t.Fatal(err)
+	}
+	if sessions, _ := s.List(ctx, "42"); len(sessions) != 0 {
+		t.Errorf("List() after RevokeAll() = %v, want none", sessions)
+	}
+}
+
+func TestSessionStoreExpiration(t *testing.T) {
+	ctx := context.Background()
+	s, server := newTestSessionStore(t, SessionOptions{IdleTimeout: time.Minute, MaxLifetime: time.Hour})
+	expiring, _ := s.Create(ctx, "42", nil)
+	server.FastForward(30 * time.Second)
+	kept, _ := s.Create(ctx, "42", nil)
+	server.FastForward(40 * time.Second)
+
+	if _, err := s.Touch(ctx, expiring.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Touch() of an idle session = %v, want ErrNotFound", err)
+	}
+	sessions, err := s.List(ctx, "42")
+	if err != nil || len(sessions) != 1 || sessions[0].ID != kept.ID {
+		t.Errorf("List() = %v, %v, want the session still alive", sessions, err)
+	}
+}
+
+func TestSessionStoreLimit(t *testing.T) {
+	ctx := context.Background()
+	s, server := newTestSessionStore(t, SessionOptions{MaxPerUser: 2})
+	now := time.Now()
+	s.now = func() time.Time {
+		now = now.Add(time.Millisecond)
+		return now
+	}
+
+	var ids []string
+	for i := 0; i < 3; i++ {
+		session, err := s.Create(ctx, "42", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, session.ID)
+	}
+	if server.Exists("session:" + ids[0]) {
+		t.Error("oldest session should be revoked beyond the limit")
+	}
+	sessions, _ := s.List(ctx, "42")
+	if len(sessions) != 2 || sessions[0].ID != ids[1] || sessions[1].ID != ids[2] {
+		t.Errorf("List() = %v, want the 2 newest sessions", sessions)
+	}
+
+	// expired sessions do not count against the limit
+	server.Del("session:" + ids[1])
+	session, err := s.Create(ctx, "42", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !server.Exists("session:"+ids[2]) || !server.Exists("session:"+session.ID) {
+		t.Error("live sessions within the limit should be kept")
+	}
+
+	// the limit holds for concurrent creations
+	s.now = time.Now
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.Create(ctx, "7", nil)
+		}()
+	}
+	wg.Wait()
+	if sessions, _ := s.List(ctx, "7"); len(sessions) != 2 {
+		t.Errorf("List() after concurrent creations = %d sessions, want 2", len(sessions))
+	}
+}
+
+func TestSessionStoreUnsupportedHelper(t *testing.T) {
+	if _, err := NewSessionStore(NewMemoryCacheHelper(nil), SessionOptions{}); err != ErrUnsupportedHelper {
+		t.Errorf("NewSessionStore() without redis = %v, want ErrUnsupportedHelper", err)
+	}
+}
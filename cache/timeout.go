@@ -0,0 +1,155 @@
+package cache
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+const defaultOperationTimeout = 3 * time.Second
+
+// blockingCommands wait on the server for as long as they are told to, the default
+// timeout would cut them short so only the deadline of the caller applies
+var blockingCommands = map[string]bool{
+	"blpop":      true,
+	"brpop":      true,
+	"brpoplpush": true,
+	"bzpopmin":   true,
+	"bzpopmax":   true,
+	"xread":      true,
+	"xreadgroup": true,
+}
+
+type cancelKey struct{}
+
+// timeoutHook bounds every command and pipeline by a default timeout unless the
+// context of the call has an earlier deadline
+type timeoutHook struct {
+	timeout  time.Duration
+	commands map[string]time.Duration
+}
+
+func newTimeoutHook(opts Options) *timeoutHook {
+	hook := &timeoutHook{
+		timeout:  opts.OperationTimeout,
+		commands: make(map[string]time.Duration, len(opts.CommandTimeouts)),
+	}
+	if hook.timeout == 0 {
+		hook.timeout = defaultOperationTimeout
+	}
+	for name, timeout := range opts.CommandTimeouts {
+		hook.commands[strings.ToLower(name)] = timeout
+	}
+	return hook
+}
+
+func (h *timeoutHook) timeoutOf(name string) time.Duration {
+	if timeout, ok := h.commands[name]; ok {
+		return timeout
+	}
+	if blockingCommands[name] {
+		return 0
+	}
+	return h.timeout
+}
+
+func (h *timeoutHook) withTimeout(ctx context.Context, timeout time.Duration) (context.Context, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if timeout <= 0 {
+		return ctx, nil
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= timeout {
+		return ctx, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return context.WithValue(ctx, cancelKey{}, cancel), nil
+}
+
+func (h *timeoutHook) release(ctx context.Context) {
+	if cancel, ok := ctx.Value(cancelKey{}).(context.CancelFunc); ok {
+		cancel()
+	}
+}
+
+func (h *timeoutHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return h.withTimeout(ctx, h.timeoutOf(cmd.Name()))
+}
+
+func (h *timeoutHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	h.release(ctx)
+	return nil
+}
+
+// BeforeProcessPipeline uses the longest timeout of the commands, none if one of them blocks
+func (h *timeoutHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	var timeout time.Duration
+	for _, cmd := range cmds {
+		cmdTimeout := h.timeoutOf(cmd.Name())
+		if cmdTimeout <= 0 {
+			timeout = 0
+			break
+		}
+		if cmdTimeout > timeout {
+			timeout = cmdTimeout
+		}
+	}
+	return h.withTimeout(ctx, timeout)
+}
+
+func (h *timeoutHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	h.release(ctx)
+	return nil
+}
+
+// withContext returns client bound to ctx, commands then give up at its deadline
+// and when it is canceled while waiting for a connection
+func withContext(ctx context.Context, client redis.UniversalClient) redis.UniversalClient {
+	switch c := client.(type) {
+	case *redis.Client:
+		return c.WithContext(ctx)
+	case *redis.ClusterClient:
+		return c.WithContext(ctx)
+	case *redis.Ring:
+		return c.WithContext(ctx)
+	}
+	return client
+}
+
+// detachedContext keeps the values of its parent, such as the span, but not its deadline
+// or cancellation
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+// detach returns a context that outlives ctx, for work that must complete once started
+func detach(ctx context.Context) context.Context {
+	return detachedContext{ctx}
+}
+
+// contextErr returns the error of ctx, or DeadlineExceeded once its deadline passed. A
+// command cut by the deadline of its connection can fail before ctx itself notices.
+func contextErr(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+		return context.DeadlineExceeded
+	}
+	return nil
+}
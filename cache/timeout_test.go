@@ -0,0 +1,50 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTimeoutHook(t *testing.T) {
+	hook := newTimeoutHook(Options{CommandTimeouts: map[string]time.Duration{"SCAN": time.Minute}})
+
+	tests := []struct {
+		name string
+		want time.Duration
+	}{
+		{"get", defaultOperationTimeout},
+		{"scan", time.Minute},
+		{"blpop", 0},
+		{"xreadgroup", 0},
+	}
+	for _, test := range tests {
+		if got := hook.timeoutOf(test.name); got != test.want {
+			t.Errorf("timeoutOf(%q) = %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	ctx, err := hook.withTimeout(context.Background(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deadline, ok := ctx.Deadline(); !ok || time.Until(deadline) > time.Second {
+		t.Errorf("withTimeout() deadline = %v, %v, want within 1s", deadline, ok)
+	}
+	hook.release(ctx)
+	if ctx.Err() == nil {
+		t.Errorf("release() should cancel the context")
+	}
+
+	parent, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	if ctx, _ := hook.withTimeout(parent, time.Second); ctx != parent {
+		t.Errorf("withTimeout() should keep an earlier deadline")
+	}
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := hook.withTimeout(canceled, time.Second); err != context.Canceled {
+		t.Errorf("withTimeout() on a canceled context = %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,437 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/binpossible49/go-libs/opentracing/jaeger"
+	"github.com/go-redis/redis/v7"
+	"github.com/opentracing/opentracing-go/ext"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultLocalCapacity          = 10000
+	defaultLocalTTL               = time.Minute
+	defaultInvalidationChannel    = "go-libs:cache:invalidation"
+	invalidationReconnectInterval = time.Second
+)
+
+// TwoTierOptions represents options of the two-tier CacheHelper
+type TwoTierOptions struct {
+	// LocalCapacity is the maximum number of entries kept in process
+	LocalCapacity int
+	// LocalTTL bounds how long an entry stays in process, it is also the staleness bound
+	// while the invalidation subscription is down. Entries read from redis are not kept
+	// past the TTL left on their key.
+	LocalTTL time.Duration
+	// EvictionPolicy of the local cache, LRU by default
+	EvictionPolicy EvictionPolicy
+	// InvalidationChannel is the pub/sub channel shared by all instances
+	InvalidationChannel string
+}
+
+// invalidationMessage is broadcast on every write, Origin lets an instance skip its own messages
+type invalidationMessage struct {
+	Origin string   `json:"o"`
+	Keys   []string `json:"k"`
+}
+
+// invalidationStripes is the number of generation counters guarding local fills, keys
+// sharing a counter only lose a fill when one of them is invalidated
+const invalidationStripes = 256
+
+type twoTierCacheHelper struct {
+	remote CacheHelper
+	client redis.UniversalClient
+	// codec encodes the local copies with the serializer of remote, uncompressed
+	codec    *codec
+	local    *localCache
+	localTTL time.Duration
+	channel  string
+	origin   string
+
+	// generations are bumped by every invalidation, a value read from remote is only
+	// kept locally when no invalidation of its key happened since the read started
+	mu          sync.Mutex
+	generations [invalidationStripes]uint64
+}
+
+// NewTwoTierCacheHelper creates an instance keeping a bounded in-process copy of remote,
+// writes are broadcast over pub/sub so that every instance evicts its local copy.
+// The subscription stops when ctx is done.
+func NewTwoTierCacheHelper(ctx context.Context, remote CacheHelper, opts TwoTierOptions) (CacheHelper, error) {
+	base, err := redisClientHelperOf(remote)
+	if err != nil {
+		return nil, err
+	}
+	codec, err := newCodec(base.valueCodec().serializer, CompressionNone, 0)
+	if err != nil {
+		return nil, err
+	}
+	client := base.redisClient()
+	if opts.LocalCapacity <= 0 {
+		opts.LocalCapacity = defaultLocalCapacity
+	}
+	if opts.LocalTTL <= 0 {
+		opts.LocalTTL = defaultLocalTTL
+	}
+	if opts.InvalidationChannel == "" {
+		opts.InvalidationChannel = defaultInvalidationChannel
+	}
+	origin, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	h := &twoTierCacheHelper{
+		remote:   remote,
+		client:   client,
+		codec:    codec,
+		local:    newLocalCache(opts.LocalCapacity, opts.EvictionPolicy),
+		localTTL: opts.LocalTTL,
+		channel:  opts.InvalidationChannel,
+		origin:   origin,
+	}
+	pubsub := client.Subscribe(h.channel)
+	if _, err := pubsub.Receive(); err != nil {
+		pubsub.Close()
+		return nil, err
+	}
+	go h.subscribe(ctx, pubsub)
+	return h, nil
+}
+
+func (h *twoTierCacheHelper) unwrap() CacheHelper {
+	return h.remote
+}
+
+// subscribe evicts keys written by other instances, the local cache is purged on every
+// (re)subscription because messages may have been missed while disconnected
+func (h *twoTierCacheHelper) subscribe(ctx context.Context, pubsub *redis.PubSub) {
+	go func() {
+		<-ctx.Done()
+		pubsub.Close()
+	}()
+	for {
+		msg, err := pubsub.Receive()
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			zap.S().Warnw("Cache invalidation subscription failed", zap.Error(err))
+			time.Sleep(invalidationReconnectInterval)
+			continue
+		}
+		switch msg := msg.(type) {
+		case *redis.Subscription:
+			h.purge()
+		case *redis.Message:
+			var message invalidationMessage
+			if err := json.Unmarshal([]byte(msg.Payload), &message); err != nil {
+				zap.S().Warnw("Invalid cache invalidation message", zap.Error(err))
+				continue
+			}
+			if message.Origin != h.origin {
+				h.evict(message.Keys...)
+			}
+		}
+	}
+}
+
+func stripeOf(key string) int {
+	hash := fnv.New32a()
+	hash.Write([]byte(key))
+	return int(hash.Sum32() % invalidationStripes)
+}
+
+// generation returns the generation of key, to be passed to fill
+func (h *twoTierCacheHelper) generation(key string) uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.generations[stripeOf(key)]
+}
+
+// evict removes keys from the local cache and fails the fills in flight
+func (h *twoTierCacheHelper) evict(keys ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, key := range keys {
+		h.generations[stripeOf(key)]++
+	}
+	h.local.del(keys...)
+}
+
+func (h *twoTierCacheHelper) purge() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i := range h.generations {
+		h.generations[i]++
+	}
+	h.local.purge()
+}
+
+// fill keeps data locally unless key was invalidated since generation was read, or for a
+// write since the invalidations of the write itself
+func (h *twoTierCacheHelper) fill(key string, data []byte, ttl time.Duration, generation uint64) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.generations[stripeOf(key)] != generation {
+		return false
+	}
+	h.local.set(key, data, ttl)
+	return true
+}
+
+// invalidate evicts keys locally and tells the other instances to do the same. The write
+// already succeeded when it is called, so a failed broadcast is logged and the other
+// instances may serve their copy for up to LocalTTL.
+func (h *twoTierCacheHelper) invalidate(ctx context.Context, keys ...string) {
+	h.evict(keys...)
+	data, err := json.Marshal(invalidationMessage{Origin: h.origin, Keys: keys})
+	if err == nil {
+		err = withContext(ctx, h.client).Publish(h.channel, string(data)).Err()
+	}
+	if err != nil {
+		zap.S().Warnw("Failed to broadcast cache invalidation", "keys", keys, zap.Error(err))
+	}
+}
+
+func (h *twoTierCacheHelper) localTTLFor(expiration time.Duration) time.Duration {
+	if expiration > 0 && expiration < h.localTTL {
+		return expiration
+	}
+	return h.localTTL
+}
+
+// remoteTTLs returns how long values of keys read from remote may be kept locally, LocalTTL
+// bounded by the TTL left in redis, 0 for keys that are gone or whose TTL is unknown
+func (h *twoTierCacheHelper) remoteTTLs(ctx context.Context, keys []string) []time.Duration {
+	pipe := withContext(ctx, h.client).Pipeline()
+	cmds := make([]*redis.DurationCmd, len(keys))
+	for i, key := range keys {
+		cmds[i] = pipe.PTTL(redisKeyOf(h.remote, key))
+	}
+	pipe.Exec()
+
+	ttls := make([]time.Duration, len(keys))
+	for i, cmd := range cmds {
+		ttl, err := cmd.Result()
+		switch {
+		case err != nil:
+		case ttl > 0:
+			ttls[i] = h.localTTLFor(ttl)
+		case ttl == -1:
+			// the key does not expire
+			ttls[i] = h.localTTL
+		}
+	}
+	return ttls
+}
+
+// writeGenerations returns for each key the generation its fill expects once the write
+// invalidated it: the generation before the write plus the invalidations of the write. A
+// concurrent write invalidating the key meanwhile makes the fill fail.
+func (h *twoTierCacheHelper) writeGenerations(keys []string) []uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	bumps := make(map[int]uint64, len(keys))
+	for _, key := range keys {
+		bumps[stripeOf(key)]++
+	}
+	generations := make([]uint64, len(keys))
+	for i, key := range keys {
+		stripe := stripeOf(key)
+		generations[i] = h.generations[stripe] + bumps[stripe]
+	}
+	return generations
+}
+
+// The local copies are encoded with the serializer of remote, remote always receives
+// and decodes the values themselves.
+
+func (h *twoTierCacheHelper) Exists(ctx context.Context, key string) (err error) {
+	span := jaeger.Start(ctx, ">helper.twoTierCacheHelper/Exists", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	if _, ok := h.local.get(key); ok {
+		span.SetTag("cache.local_hit", true)
+		return nil
+	}
+	return h.remote.Exists(ctx, key)
+}
+
+func (h *twoTierCacheHelper) Get(ctx context.Context, key string, value interface{}) (err error) {
+	span := jaeger.Start(ctx, ">helper.twoTierCacheHelper/Get", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	if data, ok := h.local.get(key); ok {
+		span.SetTag("cache.local_hit", true)
+		return h.codec.decode(data, value)
+	}
+
+	generation := h.generation(key)
+	if err = h.remote.Get(ctx, key, value); err != nil {
+		return err
+	}
+	// a key about to expire in redis is not served locally past its expiry
+	if ttl := h.remoteTTLs(ctx, []string{key})[0]; ttl > 0 {
+		if data, err := h.codec.encode(value); err == nil {
+			h.fill(key, data, ttl, generation)
+		}
+	}
+	return nil
+}
+
+func (h *twoTierCacheHelper) GetInterface(ctx context.Context, key string, value interface{}) (interface{}, error) {
+	return h.remote.GetInterface(ctx, key, value)
+}
+
+func (h *twoTierCacheHelper) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) (err error) {
+	span := jaeger.Start(ctx, ">helper.twoTierCacheHelper/Set", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	data, err := h.codec.encode(value)
+	if err != nil {
+		return err
+	}
+	generation := h.writeGenerations([]string{key})[0]
+	if err = h.remote.Set(ctx, key, value, expiration); err != nil {
+		return err
+	}
+	h.invalidate(ctx, key)
+	h.fill(key, data, h.localTTLFor(expiration), generation)
+	return nil
+}
+
+func (h *twoTierCacheHelper) Del(ctx context.Context, key string) (err error) {
+	span := jaeger.Start(ctx, ">helper.twoTierCacheHelper/Del", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	if err = h.remote.Del(ctx, key); err != nil {
+		return err
+	}
+	h.invalidate(ctx, key)
+	return nil
+}
+
+func (h *twoTierCacheHelper) Expire(ctx context.Context, key string, expiration time.Duration) (err error) {
+	span := jaeger.Start(ctx, ">helper.twoTierCacheHelper/Expire", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	if err = h.remote.Expire(ctx, key, expiration); err != nil {
+		return err
+	}
+	h.invalidate(ctx, key)
+	return nil
+}
+
+func (h *twoTierCacheHelper) DelMulti(ctx context.Context, keys ...string) (err error) {
+	span := jaeger.Start(ctx, ">helper.twoTierCacheHelper/DelMulti", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	if err = h.remote.DelMulti(ctx, keys...); err != nil {
+		return err
+	}
+	if len(keys) > 0 {
+		h.invalidate(ctx, keys...)
+	}
+	return nil
+}
+
+func (h *twoTierCacheHelper) GetKeysByPattern(ctx context.Context, pattern string, cursor uint64, limit int64) ([]string, uint64, error) {
+	return h.remote.GetKeysByPattern(ctx, pattern, cursor, limit)
+}
+
+func (h *twoTierCacheHelper) MGet(ctx context.Context, keys []string, values interface{}) (err error) {
+	span := jaeger.Start(ctx, ">helper.twoTierCacheHelper/MGet", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	elementType, err := mgetElementType(values)
+	if err != nil {
+		return err
+	}
+	raws := make([][]byte, len(keys))
+	var missingKeys []string
+	var missingPositions []int
+	var generations []uint64
+	for i, key := range keys {
+		if data, ok := h.local.get(key); ok {
+			raws[i] = data
+			continue
+		}
+		missingKeys = append(missingKeys, key)
+		missingPositions = append(missingPositions, i)
+		generations = append(generations, h.generation(key))
+	}
+
+	if len(missingKeys) > 0 {
+		// a map tells the missing keys apart from zero values
+		found := reflect.New(reflect.MapOf(reflect.TypeOf(""), elementType))
+		if err = MGet(ctx, h.remote, missingKeys, found.Interface()); err != nil {
+			return err
+		}
+		ttls := h.remoteTTLs(ctx, missingKeys)
+		for i, key := range missingKeys {
+			value := found.Elem().MapIndex(reflect.ValueOf(key))
+			if !value.IsValid() {
+				continue
+			}
+			data, err := h.codec.encode(value.Interface())
+			if err != nil {
+				return err
+			}
+			if ttls[i] > 0 {
+				h.fill(key, data, ttls[i], generations[i])
+			}
+			raws[missingPositions[i]] = data
+		}
+	}
+	return fillMGetResult(keys, raws, values, h.codec.decode)
+}
+
+func (h *twoTierCacheHelper) MSet(ctx context.Context, items ...Item) (err error) {
+	span := jaeger.Start(ctx, ">helper.twoTierCacheHelper/MSet", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+	if len(items) == 0 {
+		return nil
+	}
+
+	datas := make([][]byte, len(items))
+	keys := make([]string, len(items))
+	for i, item := range items {
+		if datas[i], err = h.codec.encode(item.Value); err != nil {
+			return err
+		}
+		keys[i] = item.Key
+	}
+	generations := h.writeGenerations(keys)
+	if err = MSet(ctx, h.remote, items...); err != nil {
+		return err
+	}
+	h.invalidate(ctx, keys...)
+	for i, item := range items {
+		h.fill(item.Key, datas[i], h.localTTLFor(item.Expiration), generations[i])
+	}
+	return nil
+}
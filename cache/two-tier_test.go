@@ -0,0 +1,262 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v7"
+)
+
+func newTestTwoTierHelper(t *testing.T, server *miniredis.Miniredis, opts Options) *twoTierCacheHelper {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	opts.Addrs = []string{server.Addr()}
+	remote, err := NewCacheHelperWithOptions(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	helper, err := NewTwoTierCacheHelper(ctx, remote, TwoTierOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return helper.(*twoTierCacheHelper)
+}
+
+func eventually(t *testing.T, condition func() bool, message string) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal(message)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestTwoTierInvalidation(t *testing.T) {
+	ctx := context.Background()
+	server, _ := newTestRedisHelper(t)
+	first := newTestTwoTierHelper(t, server, Options{})
+	second := newTestTwoTierHelper(t, server, Options{})
+
+	if err := first.Set(ctx, "key", "v1", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	// the broadcast of the write may still evict a fill that raced with it
+	var value string
+	eventually(t, func() bool {
+		if err := second.Get(ctx, "key", &value); err != nil || value != "v1" {
+			t.Fatalf("Get() = %q, %v", value, err)
+		}
+		_, ok := second.local.get("key")
+		return ok
+	}, "Get() should keep the value locally")
+
+	if err := first.Set(ctx, "key", "v2", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, func() bool {
+		_, ok := second.local.get("key")
+		return !ok
+	}, "write of another instance did not evict the local copy")
+	if err := second.Get(ctx, "key", &value); err != nil || value != "v2" {
+		t.Errorf("Get() after invalidation = %q, %v, want v2", value, err)
+	}
+
+	if err := first.Del(ctx, "key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Get(ctx, "key", &value); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() after Del = %v, want ErrNotFound", err)
+	}
+}
+
+func TestTwoTierFillGuard(t *testing.T) {
+	server, _ := newTestRedisHelper(t)
+	h := newTestTwoTierHelper(t, server, Options{})
+
+	// a value read before an invalidation must not be kept
+	generation := h.generation("key")
+	h.evict("key")
+	if h.fill("key", []byte(`"stale"`), time.Minute, generation) {
+		t.Error("fill() after an invalidation = true")
+	}
+	if _, ok := h.local.get("key"); ok {
+		t.Error("stale value was kept locally")
+	}
+
+	generation = h.generation("key")
+	h.purge()
+	if h.fill("key", []byte(`"stale"`), time.Minute, generation) {
+		t.Error("fill() after a purge = true")
+	}
+
+	if !h.fill("key", []byte(`"fresh"`), time.Minute, h.generation("key")) {
+		t.Error("fill() without invalidation = false")
+	}
+}
+
+func TestTwoTierPublishFailure(t *testing.T) {
+	ctx := context.Background()
+	server, _ := newTestRedisHelper(t)
+	h := newTestTwoTierHelper(t, server, Options{})
+	// only the broadcast fails, the write itself succeeded
+	h.client = redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1})
+	defer h.client.Close()
+
+	if err := h.Set(ctx, "key", "value", time.Minute); err != nil {
+		t.Errorf("Set() with a failed broadcast = %v, want nil", err)
+	}
+	var value string
+	if err := h.remote.Get(ctx, "key", &value); err != nil || value != "value" {
+		t.Errorf("remote Get() = %q, %v", value, err)
+	}
+}
+
+func TestTwoTierCodec(t *testing.T) {
+	ctx := context.Background()
+	server, _ := newTestRedisHelper(t)
+	h := newTestTwoTierHelper(t, server, Options{Serializer: MsgpackSerializer})
+	type user struct {
+		Name string
+		Age  int
+		// kept by msgpack, a JSON local copy would lose it
+		Token string `json:"-"`
+	}
+
+	if err := h.Set(ctx, "user", user{Name: "a", Age: 3, Token: "t"}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	// the value written through the two-tier helper is readable by the remote codec
+	var remote user
+	if err := h.remote.Get(ctx, "user", &remote); err != nil || remote.Name != "a" {
+		t.Errorf("remote Get() = %+v, %v", remote, err)
+	}
+
+	var value user
+	if err := h.Get(ctx, "user", &value); err != nil || value != (user{Name: "a", Age: 3, Token: "t"}) {
+		t.Errorf("Get() from the local copy of Set = %+v, %v", value, err)
+	}
+	h.purge()
+	value = user{}
+	if err := h.Get(ctx, "user", &value); err != nil || value != (user{Name: "a", Age: 3, Token: "t"}) {
+		t.Errorf("Get() from remote = %+v, %v", value, err)
+	}
+	value = user{}
+	if err := h.Get(ctx, "user", &value); err != nil || value != (user{Name: "a", Age: 3, Token: "t"}) {
+		t.Errorf("Get() from local = %+v, %v", value, err)
+	}
+
+	h.purge()
+	values := map[string]user{}
+	if err := h.MGet(ctx, []string{"user", "missing"}, &values); err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 1 || values["user"].Name != "a" {
+		t.Errorf("MGet() = %+v", values)
+	}
+	values = map[string]user{}
+	if err := h.MGet(ctx, []string{"user"}, &values); err != nil || values["user"].Token != "t" {
+		t.Errorf("MGet() from local = %+v, %v", values, err)
+	}
+}
+
+// interleavedHelper runs onSet once right after its first write, like a concurrent writer
+type interleavedHelper struct {
+	CacheHelper
+	onSet func()
+}
+
+func (h *interleavedHelper) unwrap() CacheHelper {
+	return h.CacheHelper
+}
+
+func (h *interleavedHelper) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	err := h.CacheHelper.Set(ctx, key, value, expiration)
+	if onSet := h.onSet; onSet != nil {
+		h.onSet = nil
+		onSet()
+	}
+	return err
+}
+
+func TestTwoTierConcurrentWriters(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, remote := newTestRedisHelper(t)
+	interleaved := &interleavedHelper{CacheHelper: remote}
+	helper, err := NewTwoTierCacheHelper(ctx, interleaved, TwoTierOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := helper.(*twoTierCacheHelper)
+
+	// B is written to redis after A and filled first, A must not replace it locally
+	interleaved.onSet = func() {
+		if err := h.Set(ctx, "key", "B", 0); err != nil {
+			t.Error(err)
+		}
+	}
+	if err := h.Set(ctx, "key", "A", 0); err != nil {
+		t.Fatal(err)
+	}
+	var value string
+	if err := h.Get(ctx, "key", &value); err != nil || value != "B" {
+		t.Errorf("Get() after concurrent writes = %q, %v, want B as in redis", value, err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				h.Set(ctx, "race", i*100+j, 0)
+				h.MSet(ctx, Item{Key: "race", Value: i*100 + j})
+			}
+		}(i)
+	}
+	wg.Wait()
+	var local, stored int
+	if err := h.Get(ctx, "race", &local); err != nil {
+		t.Fatal(err)
+	}
+	remote.Get(ctx, "race", &stored)
+	if local != stored {
+		t.Errorf("local copy = %d, redis = %d", local, stored)
+	}
+}
+
+func TestTwoTierRemoteTTL(t *testing.T) {
+	ctx := context.Background()
+	server, _ := newTestRedisHelper(t)
+	h := newTestTwoTierHelper(t, server, Options{})
+	now := time.Now()
+	h.local.now = func() time.Time { return now }
+
+	if err := h.remote.Set(ctx, "otp", "123456", 30*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	h.remote.Set(ctx, "config", "x", 0)
+	var value string
+	if err := h.Get(ctx, "otp", &value); err != nil {
+		t.Fatal(err)
+	}
+	values := map[string]string{}
+	if err := h.MGet(ctx, []string{"config"}, &values); err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(31 * time.Second)
+	if _, ok := h.local.get("otp"); ok {
+		t.Error("local copy outlived the TTL of the key in redis")
+	}
+	if _, ok := h.local.get("config"); !ok {
+		t.Error("key without expiration should be kept for LocalTTL")
+	}
+}
@@ -0,0 +1,319 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	mathrand "math/rand"
+	"sync"
+	"time"
+
+	"github.com/binpossible49/go-libs/opentracing/jaeger"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultWarmRefreshAhead  = 0.2
+	defaultWarmJitter        = 0.1
+	defaultWarmRetryInterval = 5 * time.Second
+)
+
+// ErrWarmerStarted is returned when registering keys or starting a Warmer twice
+var ErrWarmerStarted = errors.New("cache: warmer already started")
+
+// WarmState is the freshness of a key kept warm by a Warmer
+type WarmState int
+
+const (
+	// WarmPending keys have not been loaded by the Warmer yet
+	WarmPending WarmState = iota
+	// WarmFresh keys were refreshed less than their TTL ago
+	WarmFresh
+	// WarmStale keys could not be refreshed in time, their last value is served until StaleTTL
+	// runs out, or keys cached by a previous run that Start could not load
+	WarmStale
+	// WarmExpired keys could not be refreshed within StaleTTL, reads miss
+	WarmExpired
+)
+
+func (s WarmState) String() string {
+	switch s {
+	case WarmPending:
+		return "pending"
+	case WarmFresh:
+		return "fresh"
+	case WarmStale:
+		return "stale"
+	case WarmExpired:
+		return "expired"
+	}
+	return "unknown"
+}
+
+// WarmerOptions represents options of Warmer
+type WarmerOptions struct {
+	// RefreshAhead is the fraction of the TTL left when a key is refreshed, 0.2 by default
+	RefreshAhead float64
+	// Jitter spreads refreshes by up to this fraction of the TTL so that instances
+	// do not refresh together, 0.1 by default
+	Jitter float64
+	// StaleTTL keeps values in the cache past their TTL so that they are still served
+	// while refreshes fail, 0 disables it
+	StaleTTL time.Duration
+	// RetryInterval is how long to wait after a failed refresh, 5s by default
+	RetryInterval time.Duration
+}
+
+// WarmStatus describes a key kept warm by a Warmer
+type WarmStatus struct {
+	State       WarmState
+	RefreshedAt time.Time
+	// Refreshing is set while a refresh is running
+	Refreshing bool
+	// Err is the error of the last refresh
+	Err error
+}
+
+type warmEntry struct {
+	key    string
+	ttl    time.Duration
+	loader LoaderFunc
+
+	refreshedAt time.Time
+	refreshing  bool
+	err         error
+	// cached is set while the value cached by a previous run is served
+	cached bool
+}
+
+// Warmer loads registered keys at startup and refreshes them in the background
+// ahead of expiry, so that reads of reference data never go to the source
+type Warmer struct {
+	helper CacheHelper
+	opts   WarmerOptions
+	now    func() time.Time
+
+	mu      sync.Mutex
+	entries []*warmEntry
+	started bool
+	ready   bool
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// NewWarmer creates an instance writing to helper
+func NewWarmer(helper CacheHelper, opts WarmerOptions) *Warmer {
+	if opts.RefreshAhead <= 0 || opts.RefreshAhead >= 1 {
+		opts.RefreshAhead = defaultWarmRefreshAhead
+	}
+	if opts.Jitter <= 0 {
+		opts.Jitter = defaultWarmJitter
+	}
+	if opts.Jitter >= opts.RefreshAhead {
+		// keys must not be refreshed after they expired
+		opts.Jitter = opts.RefreshAhead / 2
+	}
+	if opts.RetryInterval <= 0 {
+		opts.RetryInterval = defaultWarmRetryInterval
+	}
+	return &Warmer{
+		helper: helper,
+		opts:   opts,
+		now:    time.Now,
+	}
+}
+
+// Register keeps key warm with the values of loader, ttl must be positive.
+// Keys are registered before Start.
+func (w *Warmer) Register(key string, ttl time.Duration, loader LoaderFunc) error {
+	if ttl <= 0 {
+		return fmt.Errorf("cache: warm key %q needs a positive ttl", key)
+	}
+	if loader == nil {
+		return fmt.Errorf("cache: warm key %q needs a loader", key)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.started {
+		return ErrWarmerStarted
+	}
+	w.entries = append(w.entries, &warmEntry{key: key, ttl: ttl, loader: loader})
+	return nil
+}
+
+// Start loads every key and blocks until they are all in the cache, then refreshes them
+// in the background until Stop. A key that fails to load but is still cached from a previous
+// run is served stale, otherwise Start returns the error and the Warmer is not ready.
+func (w *Warmer) Start(ctx context.Context) (err error) {
+	span := jaeger.Start(ctx, ">helper.Warmer/Start", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	w.mu.Lock()
+	if w.started {
+		w.mu.Unlock()
+		return ErrWarmerStarted
+	}
+	w.started = true
+	entries := w.entries
+	w.mu.Unlock()
+
+	errs := make([]error, len(entries))
+	var wg sync.WaitGroup
+	for i, entry := range entries {
+		wg.Add(1)
+		go func(i int, entry *warmEntry) {
+			defer wg.Done()
+			if err := w.refresh(ctx, entry); err != nil {
+				if w.helper.Exists(ctx, entry.key) == nil {
+					zap.S().Warnw("Failed to warm cache entry, serving the cached value", "key", entry.key, zap.Error(err))
+					w.mu.Lock()
+					entry.cached = true
+					w.mu.Unlock()
+					return
+				}
+				errs[i] = fmt.Errorf("cache: warm %q: %w", entry.key, err)
+			}
+		}(i, entry)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			w.mu.Lock()
+			w.started = false
+			w.mu.Unlock()
+			return err
+		}
+	}
+
+	background, cancel := context.WithCancel(context.Background())
+	w.mu.Lock()
+	w.ready = true
+	w.cancel = cancel
+	w.mu.Unlock()
+	for _, entry := range entries {
+		w.wg.Add(1)
+		go w.keepWarm(background, entry)
+	}
+	return nil
+}
+
+// Stop ends the background refreshes and waits for the running ones
+func (w *Warmer) Stop() {
+	w.mu.Lock()
+	cancel := w.cancel
+	w.cancel = nil
+	w.ready = false
+	w.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+	w.wg.Wait()
+}
+
+// Ready tells whether every key was loaded, it suits a readiness probe
+func (w *Warmer) Ready() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.ready
+}
+
+// Status returns the status of key, false when key is not registered
+func (w *Warmer) Status(key string) (WarmStatus, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, entry := range w.entries {
+		if entry.key == key {
+			return WarmStatus{
+				State:       w.stateOf(entry),
+				RefreshedAt: entry.refreshedAt,
+				Refreshing:  entry.refreshing,
+				Err:         entry.err,
+			}, true
+		}
+	}
+	return WarmStatus{}, false
+}
+
+// stateOf computes the state of entry, callers hold mu
+func (w *Warmer) stateOf(entry *warmEntry) WarmState {
+	if entry.refreshedAt.IsZero() {
+		if entry.cached {
+			return WarmStale
+		}
+		return WarmPending
+	}
+	age := w.now().Sub(entry.refreshedAt)
+	switch {
+	case age < entry.ttl:
+		return WarmFresh
+	case age < entry.ttl+w.opts.StaleTTL:
+		return WarmStale
+	}
+	return WarmExpired
+}
+
+// keepWarm refreshes entry ahead of expiry until ctx is done
+func (w *Warmer) keepWarm(ctx context.Context, entry *warmEntry) {
+	defer w.wg.Done()
+
+	delay := w.refreshDelay(entry.ttl)
+	if status, _ := w.Status(entry.key); status.State == WarmStale {
+		// Start served the value cached by a previous run
+		delay = w.opts.RetryInterval
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		delay = w.refreshDelay(entry.ttl)
+		if err := w.refresh(ctx, entry); err != nil {
+			status, _ := w.Status(entry.key)
+			zap.S().Warnw("Failed to refresh warm cache entry", "key", entry.key, "state", status.State.String(), zap.Error(err))
+			delay = w.opts.RetryInterval
+		}
+		timer.Reset(delay)
+	}
+}
+
+// refreshDelay is how long after a refresh the next one runs: the TTL less RefreshAhead,
+// moved by a random jitter
+func (w *Warmer) refreshDelay(ttl time.Duration) time.Duration {
+	jitter := (mathrand.Float64()*2 - 1) * w.opts.Jitter
+	return time.Duration(float64(ttl) * (1 - w.opts.RefreshAhead + jitter))
+}
+
+// refresh loads entry and stores it for its TTL plus StaleTTL
+func (w *Warmer) refresh(ctx context.Context, entry *warmEntry) (err error) {
+	span := jaeger.Start(ctx, ">helper.Warmer/refresh", ext.SpanKindRPCClient, opentracing.Tag{Key: "cache.key", Value: entry.key})
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	w.mu.Lock()
+	entry.refreshing = true
+	w.mu.Unlock()
+	defer func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		entry.refreshing = false
+		entry.err = err
+		if err == nil {
+			entry.refreshedAt = w.now()
+			entry.cached = false
+		}
+	}()
+
+	value, err := entry.loader(ctx)
+	if err != nil {
+		return err
+	}
+	return w.helper.Set(ctx, entry.key, value, entry.ttl+w.opts.StaleTTL)
+}
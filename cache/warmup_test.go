@@ -0,0 +1,134 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWarmerStart(t *testing.T) {
+	ctx := context.Background()
+	h := NewMemoryCacheHelper(nil)
+	w := NewWarmer(h, WarmerOptions{})
+	w.Register("currencies", time.Hour, func(ctx context.Context) (interface{}, error) {
+		return []string{"EUR", "USD"}, nil
+	})
+	if status, _ := w.Status("currencies"); status.State != WarmPending {
+		t.Errorf("state before Start = %s, want pending", status.State)
+	}
+
+	if err := w.Start(ctx); err != nil {
+		t.Fatalf("Start() = %v", err)
+	}
+	defer w.Stop()
+	if !w.Ready() {
+		t.Error("Ready() = false after Start")
+	}
+	var currencies []string
+	if err := h.Get(ctx, "currencies", &currencies); err != nil || len(currencies) != 2 {
+		t.Errorf("Get() = %v, %v", currencies, err)
+	}
+	if status, _ := w.Status("currencies"); status.State != WarmFresh {
+		t.Errorf("state after Start = %s, want fresh", status.State)
+	}
+	if err := w.Register("branches", time.Hour, func(ctx context.Context) (interface{}, error) {
+		return nil, nil
+	}); err != ErrWarmerStarted {
+		t.Errorf("Register() after Start = %v, want ErrWarmerStarted", err)
+	}
+}
+
+func TestWarmerRegister(t *testing.T) {
+	w := NewWarmer(NewMemoryCacheHelper(nil), WarmerOptions{})
+	if err := w.Register("branches", 0, func(ctx context.Context) (interface{}, error) {
+		return nil, nil
+	}); err == nil {
+		t.Error("Register() without a ttl = nil, want an error")
+	}
+	if err := w.Register("branches", time.Hour, nil); err == nil {
+		t.Error("Register() without a loader = nil, want an error")
+	}
+	if _, ok := w.Status("branches"); ok {
+		t.Error("invalid key should not be registered")
+	}
+}
+
+func TestWarmerStartFailure(t *testing.T) {
+	ctx := context.Background()
+	h := NewMemoryCacheHelper(nil)
+	failing := func(ctx context.Context) (interface{}, error) {
+		return nil, errors.New("source down")
+	}
+
+	w := NewWarmer(h, WarmerOptions{})
+	w.Register("branches", time.Hour, failing)
+	if err := w.Start(ctx); err == nil || w.Ready() {
+		t.Errorf("Start() = %v, Ready() = %v, want an error", err, w.Ready())
+	}
+
+	// a value cached by a previous run is served
+	h.Set(ctx, "branches", []string{"HQ"}, time.Hour)
+	w = NewWarmer(h, WarmerOptions{})
+	w.Register("branches", time.Hour, failing)
+	if err := w.Start(ctx); err != nil || !w.Ready() {
+		t.Errorf("Start() = %v, Ready() = %v, want the cached value served", err, w.Ready())
+	}
+	if status, _ := w.Status("branches"); status.State != WarmStale || status.Err == nil {
+		t.Errorf("status of the cached value = %s, %v, want stale with the error", status.State, status.Err)
+	}
+	w.Stop()
+}
+
+func TestWarmerRefresh(t *testing.T) {
+	ctx := context.Background()
+	h := NewMemoryCacheHelper(nil)
+	var loads int32
+	w := NewWarmer(h, WarmerOptions{})
+	w.Register("rates", 100*time.Millisecond, func(ctx context.Context) (interface{}, error) {
+		return atomic.AddInt32(&loads, 1), nil
+	})
+	if err := w.Start(ctx); err != nil {
+		t.Fatalf("Start() = %v", err)
+	}
+	time.Sleep(250 * time.Millisecond)
+	w.Stop()
+	if n := atomic.LoadInt32(&loads); n < 3 {
+		t.Errorf("loads = %d, want the key refreshed ahead of expiry", n)
+	}
+	if w.Ready() {
+		t.Error("Ready() = true after Stop")
+	}
+}
+
+func TestWarmerState(t *testing.T) {
+	now := time.Now()
+	w := NewWarmer(nil, WarmerOptions{StaleTTL: time.Minute})
+	w.now = func() time.Time { return now }
+	entry := &warmEntry{ttl: time.Minute}
+
+	for _, tc := range []struct {
+		age  time.Duration
+		want WarmState
+	}{
+		{30 * time.Second, WarmFresh},
+		{90 * time.Second, WarmStale},
+		{3 * time.Minute, WarmExpired},
+	} {
+		entry.refreshedAt = now.Add(-tc.age)
+		if got := w.stateOf(entry); got != tc.want {
+			t.Errorf("stateOf(age %s) = %s, want %s", tc.age, got, tc.want)
+		}
+	}
+}
+
+func TestWarmerRefreshDelay(t *testing.T) {
+	w := NewWarmer(nil, WarmerOptions{RefreshAhead: 0.2, Jitter: 0.1})
+	for i := 0; i < 100; i++ {
+		delay := w.refreshDelay(time.Minute)
+		if delay < 42*time.Second || delay > 54*time.Second {
+			t.Fatalf("refreshDelay() = %s, want between 42s and 54s", delay)
+		}
+	}
+}
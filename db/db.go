@@ -1,12 +1,26 @@
 package db
 
-import "database/sql"
+import (
+	"context"
+	"database/sql"
+)
 
 // DBHelper is helper of DB
 type DBHelper interface {
 	Open() *sql.DB
+	// DB returns the traced DB
+	DB() *DB
 	Close() error
+	// Deprecated: Begin ignores contexts and is not traced, use BeginTx instead
 	Begin() (*sql.Tx, error)
+	// BeginTx starts a transaction traced like DB, Commit and Rollback take its embedded *sql.Tx
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*Tx, error)
 	Commit(tx *sql.Tx) error
 	Rollback(tx *sql.Tx) error
+	// WithTx runs fn in a transaction committed when fn returns nil and rolled back when
+	// it fails or panics, the panic is raised again. WithTx called with the ctx given to fn
+	// runs in a savepoint of the same transaction and opts are ignored.
+	WithTx(ctx context.Context, opts *sql.TxOptions, fn TxFunc) error
+	// Ping checks that the database answers a query, it suits a health check
+	Ping(ctx context.Context) error
 }
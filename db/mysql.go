@@ -0,0 +1,64 @@
+package db
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	mysqlDriverName  = "mysql"
+	mysqlDefaultPort = 3306
+)
+
+// connections are recycled before the 8h wait_timeout of the server or a proxy closes them
+var mysqlPoolDefaults = poolDefaults{
+	maxOpenConns:    20,
+	maxIdleConns:    5,
+	connMaxLifetime: 3 * time.Minute,
+}
+
+// NewMySQLDBHelper creates an instance connected to MySQL
+func NewMySQLDBHelper(opts Options) (DBHelper, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	db, err := open(opts.driverName(mysqlDriverName), MySQLDSN(opts), opts, mysqlPoolDefaults)
+	if err != nil {
+		return nil, err
+	}
+	return &dbHelper{
+		db:                db,
+		traced:            NewDB(db),
+		releaseSavepoints: true,
+		healthQuery:       "SELECT 1",
+	}, nil
+}
+
+// MySQLDSN builds a go-sql-driver/mysql DSN, times are parsed into time.Time in UTC
+// and the charset is utf8mb4 unless Params overrides them. Database is escaped, which
+// go-sql-driver/mysql 1.5 and later unescapes.
+func MySQLDSN(opts Options) string {
+	port := opts.Port
+	if port == 0 {
+		port = mysqlDefaultPort
+	}
+	params := opts.params(map[string]string{
+		"parseTime": "true",
+		"loc":       "UTC",
+		"charset":   "utf8mb4",
+		"timeout":   opts.connectTimeout().String(),
+	})
+
+	query := url.Values{}
+	for key, value := range params {
+		query.Set(key, value)
+	}
+	credentials := ""
+	if opts.Username != "" {
+		credentials = opts.Username + ":" + opts.Password + "@"
+	}
+	return fmt.Sprintf("%stcp(%s)/%s?%s", credentials, net.JoinHostPort(opts.Host, strconv.Itoa(port)), url.PathEscape(opts.Database), query.Encode())
+}
@@ -0,0 +1,133 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Dialect names a database supported by DBHelper
+type Dialect string
+
+const (
+	// DialectOracle uses the oci8 driver
+	DialectOracle Dialect = "oracle"
+	// DialectPostgres uses the postgres driver of lib/pq
+	DialectPostgres Dialect = "postgres"
+	// DialectMySQL uses the mysql driver of go-sql-driver/mysql
+	DialectMySQL Dialect = "mysql"
+)
+
+const defaultConnectTimeout = 5 * time.Second
+
+// Options represents connection options of DBHelper. Drivers are not imported by this
+// package, services import the driver of their dialect: oci8 for Oracle, lib/pq for
+// Postgres or go-sql-driver/mysql for MySQL.
+type Options struct {
+	// Dialect picks the helper created by NewDBHelperWithOptions
+	Dialect Dialect
+	Host    string
+	// Port defaults to the port of the dialect
+	Port     int
+	Username string
+	Password string
+	Database string
+	// DriverName overrides the driver registered for the dialect, e.g. "pgx"
+	DriverName string
+	// Params are appended to the DSN and override the defaults of the dialect
+	Params map[string]string
+
+	// ConnectTimeout bounds establishing a connection and the ping of the constructor, 5s by default
+	ConnectTimeout  time.Duration
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+// poolDefaults are the connection pool settings used when Options leaves them empty
+type poolDefaults struct {
+	maxOpenConns    int
+	maxIdleConns    int
+	connMaxLifetime time.Duration
+}
+
+func (o *Options) validate() error {
+	if o.Host == "" {
+		return errors.New("db: host is required")
+	}
+	if o.Database == "" {
+		return errors.New("db: database is required")
+	}
+	return nil
+}
+
+func (o *Options) connectTimeout() time.Duration {
+	if o.ConnectTimeout <= 0 {
+		return defaultConnectTimeout
+	}
+	return o.ConnectTimeout
+}
+
+func (o *Options) driverName(driverName string) string {
+	if o.DriverName != "" {
+		return o.DriverName
+	}
+	return driverName
+}
+
+// params merges Params over the defaults of a dialect
+func (o *Options) params(defaults map[string]string) map[string]string {
+	params := make(map[string]string, len(defaults)+len(o.Params))
+	for key, value := range defaults {
+		params[key] = value
+	}
+	for key, value := range o.Params {
+		params[key] = value
+	}
+	return params
+}
+
+// NewDBHelperWithOptions creates the helper of opts.Dialect
+func NewDBHelperWithOptions(opts Options) (DBHelper, error) {
+	switch opts.Dialect {
+	case DialectOracle:
+		return newOracleDBHelper(opts)
+	case DialectPostgres:
+		return NewPostgresDBHelper(opts)
+	case DialectMySQL:
+		return NewMySQLDBHelper(opts)
+	}
+	return nil, fmt.Errorf("db: unsupported dialect %q", opts.Dialect)
+}
+
+// open opens and pings a pool, the settings left empty in opts take defaults
+func open(driverName, dsn string, opts Options, defaults poolDefaults) (*sql.DB, error) {
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	maxOpenConns, maxIdleConns, connMaxLifetime := opts.MaxOpenConns, opts.MaxIdleConns, opts.ConnMaxLifetime
+	if maxOpenConns <= 0 {
+		maxOpenConns = defaults.maxOpenConns
+	}
+	if maxIdleConns <= 0 {
+		maxIdleConns = defaults.maxIdleConns
+	}
+	if connMaxLifetime <= 0 {
+		connMaxLifetime = defaults.connMaxLifetime
+	}
+	db.SetMaxOpenConns(maxOpenConns)
+	db.SetMaxIdleConns(maxIdleConns)
+	db.SetConnMaxLifetime(connMaxLifetime)
+
+	ctx, cancel := context.WithTimeout(context.Background(), opts.connectTimeout())
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
@@ -1,33 +1,94 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"net/url"
 
 	log "github.com/binpossible49/go-libs/log"
+	"github.com/binpossible49/go-libs/opentracing/jaeger"
+	"github.com/opentracing/opentracing-go/ext"
 
 	"go.uber.org/zap"
 )
 
 type dbHelper struct {
-	db *sql.DB
+	db     *sql.DB
+	traced *DB
+	// releaseSavepoints is false for Oracle which has no RELEASE SAVEPOINT
+	releaseSavepoints bool
+	// healthQuery is run by Ping
+	healthQuery string
 }
 
-// NewDBHelper creates an instance
+const (
+	oracleDriverName  = "oci8"
+	oracleDefaultPort = 1521
+)
+
+// oraclePoolDefaults keep the settings of database/sql
+var oraclePoolDefaults = poolDefaults{
+	maxIdleConns: 2,
+}
+
+// NewOracleDBHelper creates an instance, it panics when host or database is empty or when
+// the database does not answer a ping within 5s
 func NewOracleDBHelper(host string, port int, username, password, database string) DBHelper {
-	db, err := initOracle(host, port, username, password, database)
+	helper, err := newOracleDBHelper(Options{
+		Host:     host,
+		Port:     port,
+		Username: username,
+		Password: password,
+		Database: database,
+	})
 	if err != nil {
 		log.Logger.Panic("Failed to init oracle", zap.Error(err))
 	}
+	return helper
+}
+
+func newOracleDBHelper(opts Options) (DBHelper, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	db, err := open(opts.driverName(oracleDriverName), OracleDSN(opts), opts, oraclePoolDefaults)
+	if err != nil {
+		return nil, err
+	}
 	return &dbHelper{
-		db: db,
+		db:          db,
+		traced:      NewDB(db),
+		healthQuery: "SELECT 1 FROM DUAL",
+	}, nil
+}
+
+// OracleDSN builds an oci8 DSN, Database is the service name. The password is quoted
+// so that it may contain / and @, Oracle passwords cannot contain ".
+func OracleDSN(opts Options) string {
+	port := opts.Port
+	if port == 0 {
+		port = oracleDefaultPort
+	}
+	dsn := fmt.Sprintf(`%v/"%v"@%v:%v/%v`, opts.Username, opts.Password, opts.Host, port, opts.Database)
+	if len(opts.Params) > 0 {
+		query := url.Values{}
+		for key, value := range opts.Params {
+			query.Set(key, value)
+		}
+		dsn += "?" + query.Encode()
 	}
+	return dsn
 }
 
 func (h *dbHelper) Open() *sql.DB {
 	return h.db
 }
 
+func (h *dbHelper) DB() *DB {
+	return h.traced
+}
+
 func (h *dbHelper) Close() error {
 	return h.db.Close()
 }
@@ -36,6 +97,10 @@ func (h *dbHelper) Begin() (*sql.Tx, error) {
 	return h.db.Begin()
 }
 
+func (h *dbHelper) BeginTx(ctx context.Context, opts *sql.TxOptions) (*Tx, error) {
+	return h.traced.BeginTx(ctx, opts)
+}
+
 func (h *dbHelper) Commit(tx *sql.Tx) error {
 	return tx.Commit()
 }
@@ -44,15 +109,19 @@ func (h *dbHelper) Rollback(tx *sql.Tx) error {
 	return tx.Rollback()
 }
 
-func initOracle(host string, port int, username, password, database string) (*sql.DB, error) {
-	connectionString := fmt.Sprintf("%v/%v@%v:%v/%v", username, password, host, port, database)
+func (h *dbHelper) WithTx(ctx context.Context, opts *sql.TxOptions, fn TxFunc) error {
+	return withTx(ctx, h.traced, h.releaseSavepoints, opts, fn)
+}
 
-	db, err := sql.Open("oci8", connectionString)
-	if err != nil {
-		return nil, err
-	}
-	if err := db.Ping(); err != nil {
-		return nil, err
+func (h *dbHelper) Ping(ctx context.Context) (err error) {
+	span := jaeger.Start(ctx, ">db.dbHelper/Ping", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	if err := h.db.PingContext(ctx); err != nil {
+		return err
 	}
-	return db, nil
+	var one int
+	return h.db.QueryRowContext(ctx, h.healthQuery).Scan(&one)
 }
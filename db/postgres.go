@@ -0,0 +1,68 @@
+package db
+
+import (
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	postgresDriverName  = "postgres"
+	postgresDefaultPort = 5432
+)
+
+var postgresPoolDefaults = poolDefaults{
+	maxOpenConns:    20,
+	maxIdleConns:    5,
+	connMaxLifetime: 30 * time.Minute,
+}
+
+// NewPostgresDBHelper creates an instance connected to Postgres
+func NewPostgresDBHelper(opts Options) (DBHelper, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	db, err := open(opts.driverName(postgresDriverName), PostgresDSN(opts), opts, postgresPoolDefaults)
+	if err != nil {
+		return nil, err
+	}
+	return &dbHelper{
+		db:                db,
+		traced:            NewDB(db),
+		releaseSavepoints: true,
+		healthQuery:       "SELECT 1",
+	}, nil
+}
+
+// PostgresDSN builds a postgres:// URL, TLS is required unless Params sets sslmode
+func PostgresDSN(opts Options) string {
+	port := opts.Port
+	if port == 0 {
+		port = postgresDefaultPort
+	}
+	// connect_timeout is in seconds and 0 waits forever
+	timeout := int(opts.connectTimeout() / time.Second)
+	if timeout < 1 {
+		timeout = 1
+	}
+	params := opts.params(map[string]string{
+		"sslmode":         "require",
+		"connect_timeout": strconv.Itoa(timeout),
+	})
+
+	query := url.Values{}
+	for key, value := range params {
+		query.Set(key, value)
+	}
+	dsn := url.URL{
+		Scheme:   "postgres",
+		Host:     net.JoinHostPort(opts.Host, strconv.Itoa(port)),
+		Path:     "/" + opts.Database,
+		RawQuery: query.Encode(),
+	}
+	if opts.Username != "" {
+		dsn.User = url.UserPassword(opts.Username, opts.Password)
+	}
+	return dsn.String()
+}
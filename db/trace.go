@@ -0,0 +1,261 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/binpossible49/go-libs/opentracing/jaeger"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+const maxStatementLength = 1024
+
+// DB is a *sql.DB creating a span per statement, the methods without a context are not traced
+type DB struct {
+	*sql.DB
+}
+
+// NewDB wraps db
+func NewDB(db *sql.DB) *DB {
+	return &DB{DB: db}
+}
+
+func (db *DB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return execContext(ctx, ">db.DB/Exec", db.DB.ExecContext, query, args...)
+}
+
+func (db *DB) QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	return queryContext(ctx, ">db.DB/Query", db.DB.QueryContext, query, args...)
+}
+
+func (db *DB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *Row {
+	span := startStatementSpan(ctx, ">db.DB/QueryRow", query)
+	return &Row{row: db.DB.QueryRowContext(ctx, query, args...), span: span}
+}
+
+// BeginTx starts a transaction tracing its statements
+func (db *DB) BeginTx(ctx context.Context, opts *sql.TxOptions) (tx *Tx, err error) {
+	span := jaeger.Start(ctx, ">db.DB/BeginTx", ext.SpanKindRPCClient, opentracing.Tag{Key: string(ext.DBType), Value: "sql"})
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	sqlTx, err := db.DB.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{Tx: sqlTx}, nil
+}
+
+func (tx *Tx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return execContext(ctx, ">db.Tx/Exec", tx.Tx.ExecContext, query, args...)
+}
+
+func (tx *Tx) QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	return queryContext(ctx, ">db.Tx/Query", tx.Tx.QueryContext, query, args...)
+}
+
+func (tx *Tx) QueryRowContext(ctx context.Context, query string, args ...interface{}) *Row {
+	span := startStatementSpan(ctx, ">db.Tx/QueryRow", query)
+	return &Row{row: tx.Tx.QueryRowContext(ctx, query, args...), span: span}
+}
+
+// Row is the row of a traced single-row query, its span ends when it is scanned with the
+// error of the query, sql.ErrNoRows included
+type Row struct {
+	row  *sql.Row
+	span opentracing.Span
+	once sync.Once
+}
+
+// Scan copies the columns of the row into dest like sql.Row.Scan
+func (r *Row) Scan(dest ...interface{}) error {
+	err := r.row.Scan(dest...)
+	r.once.Do(func() {
+		count := 0
+		if err == nil {
+			count = 1
+		}
+		r.span.SetTag("db.rows", count)
+		jaeger.Finish(r.span, err)
+	})
+	return err
+}
+
+// Rows are the rows of a traced query, its span ends when the rows are read or closed
+type Rows struct {
+	*sql.Rows
+	span  opentracing.Span
+	count int
+	once  sync.Once
+}
+
+func (r *Rows) Next() bool {
+	if r.Rows.Next() {
+		r.count++
+		return true
+	}
+	r.finish(r.Rows.Err())
+	return false
+}
+
+func (r *Rows) Close() error {
+	err := r.Rows.Close()
+	r.finish(err)
+	return err
+}
+
+func (r *Rows) finish(err error) {
+	r.once.Do(func() {
+		r.span.SetTag("db.rows", r.count)
+		jaeger.Finish(r.span, err)
+	})
+}
+
+func execContext(ctx context.Context, methodName string, exec func(context.Context, string, ...interface{}) (sql.Result, error), query string, args ...interface{}) (result sql.Result, err error) {
+	span := startStatementSpan(ctx, methodName, query)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	result, err = exec(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	if rows, err := result.RowsAffected(); err == nil {
+		span.SetTag("db.rows", rows)
+	}
+	return result, nil
+}
+
+func queryContext(ctx context.Context, methodName string, query func(context.Context, string, ...interface{}) (*sql.Rows, error), statement string, args ...interface{}) (*Rows, error) {
+	span := startStatementSpan(ctx, methodName, statement)
+	rows, err := query(ctx, statement, args...)
+	if err != nil {
+		jaeger.Finish(span, err)
+		return nil, err
+	}
+	return &Rows{Rows: rows, span: span}, nil
+}
+
+func startStatementSpan(ctx context.Context, methodName, query string) opentracing.Span {
+	return jaeger.Start(ctx, methodName, ext.SpanKindRPCClient,
+		opentracing.Tag{Key: string(ext.DBType), Value: "sql"},
+		opentracing.Tag{Key: string(ext.DBStatement), Value: sanitizeStatement(query)},
+	)
+}
+
+// sanitizeStatement replaces literals with ? so that values embedded in statements do not
+// end up in traces, and collapses whitespace. Masked are strings quoted with ' or ", whose
+// quotes are escaped by doubling them or with a backslash, Postgres $$ and $tag$ bodies, and
+// numbers such as 1.5, 1e3 or 0x1F. Double quoted identifiers of Postgres and Oracle are
+// masked as well, comments are kept as is.
+func sanitizeStatement(query string) string {
+	var b strings.Builder
+	b.Grow(len(query))
+	space := false
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			space = b.Len() > 0
+			continue
+		case space:
+			b.WriteByte(' ')
+			space = false
+		}
+
+		tag := ""
+		if c == '$' && (i == 0 || !isIdentifier(query[i-1])) {
+			tag = dollarTag(query[i:])
+		}
+		switch {
+		case c == '\'' || c == '"':
+			i = skipQuoted(query, i)
+			b.WriteByte('?')
+		case tag != "":
+			end := strings.Index(query[i+len(tag):], tag)
+			if end < 0 {
+				i = len(query)
+			} else {
+				i += len(tag) + end + len(tag) - 1
+			}
+			b.WriteByte('?')
+		case isDigit(c) && (i == 0 || !isIdentifier(query[i-1])):
+			// hexadecimal and exponent digits are letters
+			for i+1 < len(query) && (isDigit(query[i+1]) || isLetter(query[i+1]) || query[i+1] == '.' || query[i+1] == '_') {
+				i++
+			}
+			b.WriteByte('?')
+		default:
+			b.WriteByte(c)
+		}
+		if b.Len() > maxStatementLength {
+			return truncate(b.String(), maxStatementLength)
+		}
+	}
+	return b.String()
+}
+
+// skipQuoted returns the index of the quote closing the literal opened at i, or the end of
+// query when it is not closed. A doubled quote or a backslash escapes a quote.
+func skipQuoted(query string, i int) int {
+	quote := query[i]
+	for i++; i < len(query); i++ {
+		switch query[i] {
+		case '\\':
+			i++
+		case quote:
+			if i+1 < len(query) && query[i+1] == quote {
+				i++
+				continue
+			}
+			return i
+		}
+	}
+	return len(query)
+}
+
+// dollarTag returns the $tag$ opening a dollar quoted string at the start of query, "" when
+// there is none, e.g. for the bind parameter $1
+func dollarTag(query string) string {
+	for i := 1; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '$':
+			return query[:i+1]
+		case c != '_' && !isLetter(c) && (i == 1 || !isDigit(c)):
+			return ""
+		}
+	}
+	return ""
+}
+
+// truncate cuts s to at most n bytes without splitting a UTF-8 character
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// isIdentifier tells whether c may precede a digit in an identifier or a bind parameter
+// such as $1 or :1
+func isIdentifier(c byte) bool {
+	return c == '_' || c == '$' || c == ':' || c == '@' || isDigit(c) || isLetter(c)
+}
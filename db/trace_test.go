@@ -0,0 +1,144 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+)
+
+func (c *recorderConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.r.record(query)
+	if strings.Contains(query, "FALSE") {
+		return &recorderRows{}, nil
+	}
+	return &recorderRows{left: 2}, nil
+}
+
+// recorderRows returns left rows of a single column
+type recorderRows struct {
+	left int
+}
+
+func (r *recorderRows) Columns() []string {
+	return []string{"n"}
+}
+
+func (r *recorderRows) Close() error {
+	return nil
+}
+
+func (r *recorderRows) Next(dest []driver.Value) error {
+	if r.left == 0 {
+		return io.EOF
+	}
+	dest[0] = int64(r.left)
+	r.left--
+	return nil
+}
+
+func TestSanitizeStatement(t *testing.T) {
+	for _, tc := range []struct {
+		query, want string
+	}{
+		{"SELECT * FROM users WHERE id = 42", "SELECT * FROM users WHERE id = ?"},
+		{"UPDATE t SET name = 'O''Brien', score = 1.5 WHERE id = $1", "UPDATE t SET name = ?, score = ? WHERE id = $1"},
+		{"SELECT col1\n\tFROM t2 WHERE a = :1", "SELECT col1 FROM t2 WHERE a = :1"},
+		{"  INSERT INTO t VALUES (?, 'x')  ", "INSERT INTO t VALUES (?, ?)"},
+		// MySQL
+		{`SELECT * FROM t WHERE name = 'it\'s' AND city = "Lyon"`, "SELECT * FROM t WHERE name = ? AND city = ?"},
+		{`UPDATE t SET path = 'C:\\' WHERE note = "say \"hi\""`, "UPDATE t SET path = ? WHERE note = ?"},
+		{"SELECT * FROM t WHERE flags = 0x1F AND n = 1e3", "SELECT * FROM t WHERE flags = ? AND n = ?"},
+		// Postgres
+		{"SELECT $$it's secret$$, $body$x $$ y$body$ WHERE a = $1", "SELECT ?, ? WHERE a = $1"},
+		{"SELECT a$b FROM t", "SELECT a$b FROM t"},
+		{"SELECT 'unterminated secret", "SELECT ?"},
+		{"SELECT $x$unterminated secret", "SELECT ?"},
+	} {
+		if got := sanitizeStatement(tc.query); got != tc.want {
+			t.Errorf("sanitizeStatement(%q) = %q, want %q", tc.query, got, tc.want)
+		}
+	}
+}
+
+func TestSanitizeStatementTruncation(t *testing.T) {
+	// a 3 byte character spans the limit
+	query := strings.Repeat("a", maxStatementLength-1) + "€ FROM t"
+	got := sanitizeStatement(query)
+	if !utf8.ValidString(got) || len(got) != maxStatementLength-1 {
+		t.Errorf("sanitizeStatement() = %d bytes, valid UTF-8 %v, want %d bytes", len(got), utf8.ValidString(got), maxStatementLength-1)
+	}
+	if got := sanitizeStatement(strings.Repeat("x", 2*maxStatementLength)); len(got) != maxStatementLength {
+		t.Errorf("sanitizeStatement() = %d bytes, want %d", len(got), maxStatementLength)
+	}
+}
+
+func TestTracedStatements(t *testing.T) {
+	tracer := mocktracer.New()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+	ctx := context.Background()
+	db := newRecorderHelper(t, true).DB()
+	if _, err := db.ExecContext(ctx, "DELETE FROM t WHERE id = 7"); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := db.QueryContext(ctx, "SELECT n FROM t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+	}
+	rows.Close()
+
+	spans := tracer.FinishedSpans()
+	if len(spans) != 2 {
+		t.Fatalf("finished spans = %d, want 2", len(spans))
+	}
+	if statement := spans[0].Tag("db.statement"); statement != "DELETE FROM t WHERE id = ?" {
+		t.Errorf("db.statement = %v", statement)
+	}
+	if affected := spans[0].Tag("db.rows"); affected != int64(1) {
+		t.Errorf("db.rows of Exec = %v, want 1", affected)
+	}
+	if count := spans[1].Tag("db.rows"); count != 2 {
+		t.Errorf("db.rows of Query = %v, want 2", count)
+	}
+}
+
+func TestTracedQueryRow(t *testing.T) {
+	tracer := mocktracer.New()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+	ctx := context.Background()
+	db := newRecorderHelper(t, true).DB()
+	var n int64
+	row := db.QueryRowContext(ctx, "SELECT n FROM t WHERE id = 1")
+	if len(tracer.FinishedSpans()) != 0 {
+		t.Error("span of QueryRow finished before Scan")
+	}
+	if err := row.Scan(&n); err != nil || n != 2 {
+		t.Fatalf("Scan() = %d, %v", n, err)
+	}
+	if err := db.QueryRowContext(ctx, "SELECT n FROM t WHERE FALSE").Scan(&n); err != sql.ErrNoRows {
+		t.Fatalf("Scan() of no row = %v, want sql.ErrNoRows", err)
+	}
+
+	spans := tracer.FinishedSpans()
+	if len(spans) != 2 {
+		t.Fatalf("finished spans = %d, want 2", len(spans))
+	}
+	if count, failed := spans[0].Tag("db.rows"), spans[0].Tag("error"); count != 1 || failed != nil {
+		t.Errorf("span of a row = db.rows %v, error %v, want 1 row without error", count, failed)
+	}
+	if count, failed := spans[1].Tag("db.rows"), spans[1].Tag("error"); count != 0 || failed != true {
+		t.Errorf("span of no row = db.rows %v, error %v, want 0 rows and an error", count, failed)
+	}
+}
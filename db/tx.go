@@ -0,0 +1,83 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+// Tx is a transaction run by WithTx, nested WithTx calls share it through savepoints
+type Tx struct {
+	*sql.Tx
+	depth int
+}
+
+// TxFunc runs in a transaction, ctx carries the transaction to nested WithTx calls
+type TxFunc func(ctx context.Context, tx *Tx) error
+
+type txKey struct {
+	db *sql.DB
+}
+
+// withTx runs fn in a transaction of db, or in a savepoint of the transaction of db in ctx.
+// releaseSavepoints is false for databases without RELEASE SAVEPOINT.
+func withTx(ctx context.Context, db *DB, releaseSavepoints bool, opts *sql.TxOptions, fn TxFunc) (err error) {
+	if outer, ok := ctx.Value(txKey{db.DB}).(*Tx); ok {
+		return withSavepoint(ctx, db, outer, releaseSavepoints, fn)
+	}
+
+	tx, err := db.BeginTx(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			rollback(tx)
+			panic(p)
+		}
+	}()
+
+	if err := fn(context.WithValue(ctx, txKey{db.DB}, tx), tx); err != nil {
+		rollback(tx)
+		return err
+	}
+	return tx.Commit()
+}
+
+// withSavepoint runs fn in a savepoint of outer, rolled back to on error or panic
+func withSavepoint(ctx context.Context, db *DB, outer *Tx, releaseSavepoints bool, fn TxFunc) error {
+	tx := &Tx{Tx: outer.Tx, depth: outer.depth + 1}
+	savepoint := fmt.Sprintf("sp_%d", tx.depth)
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT "+savepoint); err != nil {
+		return err
+	}
+	rollbackToSavepoint := func() {
+		if _, err := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+savepoint); err != nil {
+			zap.S().Warnw("Failed to roll back to savepoint", "savepoint", savepoint, zap.Error(err))
+		}
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			rollbackToSavepoint()
+			panic(p)
+		}
+	}()
+
+	if err := fn(context.WithValue(ctx, txKey{db.DB}, tx), tx); err != nil {
+		rollbackToSavepoint()
+		return err
+	}
+	if !releaseSavepoints {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT "+savepoint)
+	return err
+}
+
+func rollback(tx *Tx) {
+	if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+		zap.S().Warnw("Failed to roll back transaction", zap.Error(err))
+	}
+}
@@ -0,0 +1,153 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// recorder is a database/sql driver recording the statements it receives
+type recorder struct {
+	mu         sync.Mutex
+	statements []string
+}
+
+func (r *recorder) record(statement string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.statements = append(r.statements, statement)
+}
+
+func (r *recorder) take() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	statements := r.statements
+	r.statements = nil
+	return statements
+}
+
+func (r *recorder) Open(name string) (driver.Conn, error) {
+	return &recorderConn{r}, nil
+}
+
+type recorderConn struct {
+	r *recorder
+}
+
+func (c *recorderConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+
+func (c *recorderConn) Close() error {
+	return nil
+}
+
+func (c *recorderConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *recorderConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	statement := "BEGIN"
+	if opts.ReadOnly {
+		statement += " READ ONLY"
+	}
+	c.r.record(statement)
+	return c, nil
+}
+
+func (c *recorderConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.r.record(query)
+	return driver.RowsAffected(1), nil
+}
+
+func (c *recorderConn) Commit() error {
+	c.r.record("COMMIT")
+	return nil
+}
+
+func (c *recorderConn) Rollback() error {
+	c.r.record("ROLLBACK")
+	return nil
+}
+
+var testRecorder = &recorder{}
+
+func init() {
+	sql.Register("recorder", testRecorder)
+}
+
+func newRecorderHelper(t *testing.T, releaseSavepoints bool) *dbHelper {
+	db, err := sql.Open("recorder", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testRecorder.take()
+	return &dbHelper{db: db, traced: NewDB(db), releaseSavepoints: releaseSavepoints}
+}
+
+func TestWithTx(t *testing.T) {
+	ctx := context.Background()
+	h := newRecorderHelper(t, true)
+	boom := errors.New("boom")
+
+	err := h.WithTx(ctx, &sql.TxOptions{ReadOnly: true}, func(ctx context.Context, tx *Tx) error {
+		_, err := tx.ExecContext(ctx, "UPDATE a")
+		return err
+	})
+	if want := []string{"BEGIN READ ONLY", "UPDATE a", "COMMIT"}; err != nil || !reflect.DeepEqual(testRecorder.take(), want) {
+		t.Errorf("WithTx() = %v, want %v", err, want)
+	}
+
+	err = h.WithTx(ctx, nil, func(ctx context.Context, tx *Tx) error {
+		return boom
+	})
+	if want := []string{"BEGIN", "ROLLBACK"}; err != boom || !reflect.DeepEqual(testRecorder.take(), want) {
+		t.Errorf("WithTx() = %v, want boom and %v", err, want)
+	}
+}
+
+func TestWithTxPanic(t *testing.T) {
+	h := newRecorderHelper(t, true)
+	defer func() {
+		if p := recover(); p != "boom" {
+			t.Errorf("recover() = %v, want the panic raised again", p)
+		}
+		if want := []string{"BEGIN", "ROLLBACK"}; !reflect.DeepEqual(testRecorder.take(), want) {
+			t.Errorf("statements should be %v", want)
+		}
+	}()
+	h.WithTx(context.Background(), nil, func(ctx context.Context, tx *Tx) error {
+		panic("boom")
+	})
+}
+
+func TestWithTxNested(t *testing.T) {
+	ctx := context.Background()
+	boom := errors.New("boom")
+
+	for _, tc := range []struct {
+		releaseSavepoints bool
+		want              []string
+	}{
+		{true, []string{"BEGIN", "SAVEPOINT sp_1", "RELEASE SAVEPOINT sp_1", "SAVEPOINT sp_1", "ROLLBACK TO SAVEPOINT sp_1", "COMMIT"}},
+		{false, []string{"BEGIN", "SAVEPOINT sp_1", "SAVEPOINT sp_1", "ROLLBACK TO SAVEPOINT sp_1", "COMMIT"}},
+	} {
+		h := newRecorderHelper(t, tc.releaseSavepoints)
+		err := h.WithTx(ctx, nil, func(ctx context.Context, tx *Tx) error {
+			if err := h.WithTx(ctx, nil, func(ctx context.Context, tx *Tx) error { return nil }); err != nil {
+				return err
+			}
+			if err := h.WithTx(ctx, nil, func(ctx context.Context, tx *Tx) error { return boom }); err != boom {
+				t.Errorf("nested WithTx() = %v, want boom", err)
+			}
+			return nil
+		})
+		if got := testRecorder.take(); err != nil || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("WithTx() = %v, statements %v, want %v", err, got, tc.want)
+		}
+	}
+}
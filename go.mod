@@ -4,16 +4,21 @@ go 1.14
 
 require (
 	github.com/Shopify/sarama v1.26.4
-	github.com/go-redis/redis v6.15.8+incompatible
+	github.com/alicebob/miniredis/v2 v2.30.0
+	github.com/go-redis/redis/v7 v7.4.0
 	github.com/gogo/protobuf v1.3.1
 	github.com/golang/protobuf v1.4.2
+	github.com/golang/snappy v0.0.1
 	github.com/grpc-ecosystem/go-grpc-middleware v1.2.0
 	github.com/grpc-ecosystem/grpc-gateway v1.14.6
 	github.com/opentracing/opentracing-go v1.2.0
+	github.com/prometheus/client_golang v1.7.1
 	github.com/sarulabs/di v2.0.0+incompatible
 	github.com/uber/jaeger-client-go v2.24.0+incompatible
 	github.com/uber/jaeger-lib v2.2.0+incompatible
+	github.com/vmihailenco/msgpack/v4 v4.3.12
 	go.uber.org/zap v1.15.0
+	golang.org/x/sync v0.0.0-20200625203802-6e8e738ad208
 	google.golang.org/genproto v0.0.0-20200702021140-07506425bd67
 	google.golang.org/grpc v1.30.0
 	google.golang.org/protobuf v1.25.0
@@ -2,9 +2,10 @@ package jaeger
 
 import (
 	"context"
+	"errors"
 	"io"
 
-	"github.com/go-redis/redis"
+	"github.com/go-redis/redis/v7"
 	"github.com/opentracing/opentracing-go"
 	"github.com/opentracing/opentracing-go/ext"
 	"github.com/opentracing/opentracing-go/log"
@@ -45,7 +46,7 @@ func Continue(spanCtx opentracing.SpanContext, methodName string, spanKind opent
 
 // Finish finalizes span
 func Finish(span opentracing.Span, err error) {
-	if err != nil && err != io.EOF && err != redis.Nil {
+	if err != nil && err != io.EOF && !errors.Is(err, redis.Nil) {
 		ext.Error.Set(span, true)
 		span.LogFields(log.String("event", "error"), log.String("message", err.Error()))
 	}
@@ -0,0 +1,38 @@
+package jaeger
+
+import (
+	"github.com/opentracing/opentracing-go"
+)
+
+// InjectRedisStreamFields injects span into the fields of a redis stream entry
+func InjectRedisStreamFields(span opentracing.Span, fields map[string]interface{}) error {
+	carrier := redisStreamFieldsCarrier(fields)
+	return span.Tracer().Inject(span.Context(), opentracing.TextMap, carrier)
+}
+
+// ExtractRedisStreamFields extracts span from the fields of a redis stream entry
+func ExtractRedisStreamFields(fields map[string]interface{}) (opentracing.SpanContext, error) {
+	carrier := redisStreamFieldsCarrier(fields)
+	return opentracing.GlobalTracer().Extract(opentracing.TextMap, carrier)
+}
+
+type redisStreamFieldsCarrier map[string]interface{}
+
+// ForeachKey conforms to the TextMapReader interface.
+func (c redisStreamFieldsCarrier) ForeachKey(handler func(key, val string) error) error {
+	for key, val := range c {
+		value, ok := val.(string)
+		if !ok {
+			continue
+		}
+		if err := handler(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Set implements Set() of opentracing.TextMapWriter.
+func (c redisStreamFieldsCarrier) Set(key, val string) {
+	c[key] = val
+}
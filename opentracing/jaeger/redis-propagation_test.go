@@ -0,0 +1,50 @@
+package jaeger
+
+import (
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+)
+
+func useMockTracer(t *testing.T) *mocktracer.MockTracer {
+	t.Helper()
+	previous := opentracing.GlobalTracer()
+	tracer := mocktracer.New()
+	opentracing.SetGlobalTracer(tracer)
+	t.Cleanup(func() {
+		opentracing.SetGlobalTracer(previous)
+	})
+	return tracer
+}
+
+func TestRedisStreamFieldsPropagation(t *testing.T) {
+	tracer := useMockTracer(t)
+	span := tracer.StartSpan("producer")
+	fields := map[string]interface{}{"payload": "data"}
+
+	if err := InjectRedisStreamFields(span, fields); err != nil {
+		t.Fatal(err)
+	}
+	if fields["payload"] != "data" || len(fields) < 2 {
+		t.Errorf("fields = %v, want the payload and the span context", fields)
+	}
+
+	// values read from redis may be of any type, only strings carry the span context
+	fields["count"] = int64(3)
+	spanCtx, err := ExtractRedisStreamFields(fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, want := spanCtx.(mocktracer.MockSpanContext), span.Context().(mocktracer.MockSpanContext)
+	if got.TraceID != want.TraceID || got.SpanID != want.SpanID {
+		t.Errorf("extracted span context = %+v, want %+v", got, want)
+	}
+}
+
+func TestExtractRedisStreamFieldsWithoutSpan(t *testing.T) {
+	useMockTracer(t)
+	if _, err := ExtractRedisStreamFields(map[string]interface{}{"payload": "data"}); err != opentracing.ErrSpanContextNotFound {
+		t.Errorf("ExtractRedisStreamFields() = %v, want ErrSpanContextNotFound", err)
+	}
+}